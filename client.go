@@ -0,0 +1,266 @@
+package jinx
+
+import (
+	"context"
+	"github.com/imlgw/jinx/errors"
+	"github.com/imlgw/jinx/internal"
+	"golang.org/x/sys/unix"
+	"log"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Client 客户端，主动建立的连接同样注册到 eventloop 中，通过 OnOpen/OnRead/OnClose 等回调处理
+type Client interface {
+	connHandler
+
+	// Start 启动所有 eventloop，不会阻塞
+	Start() error
+
+	// Stop 关闭所有连接并退出 eventloop
+	Stop() error
+
+	// Dial 建立连接并阻塞等待连接完成，连接建立后先回调 OnOpen 再返回。
+	// 不能在 client 的回调中调用，会阻塞 eventloop，回调中需要使用 AsyncDial
+	Dial(network, addr string) (Conn, error)
+
+	// DialContext 与 Dial 相同，ctx 结束（超时或者取消）时返回 ctx.Err()，包括 DNS 解析以及建立连接的时间。
+	// ctx 结束之后才建立完成的连接会被关闭（OnOpen 之后回调 OnClose）
+	DialContext(ctx context.Context, network, addr string) (Conn, error)
+
+	// AsyncDial 发起连接后立即返回，连接完成或者失败后在 eventloop 中回调 callback，成功时 callback 在 OnOpen 之后执行。
+	// addr 需要 DNS 解析时在新的 goroutine 中解析，不会阻塞调用方，解析失败同样通过 callback 返回
+	AsyncDial(network, addr string, callback func(c Conn, err error)) error
+
+	// AfterFunc d 之后在某个 eventloop 中执行 f，可以在任意 goroutine 中调用
+	AfterFunc(d time.Duration, f func()) (*Timer, error)
+
+	// Every 每隔 d 在同一个 eventloop 中执行一次 f，直到调用 Timer.Stop
+	Every(d time.Duration, f func()) (*Timer, error)
+}
+
+type client struct {
+	opts      *Options
+	loopGroup *eventLoopGroup
+	started   int32
+	once      sync.Once
+	wg        sync.WaitGroup
+	eventHandler
+}
+
+func NewClient(opts ...Option) (Client, error) {
+	options := LoadOptions(opts...)
+	if options.LoopNum <= 0 {
+		options.LoopNum = runtime.NumCPU()
+	}
+
+	c := &client{opts: options}
+	c.loopGroup = newEventGroup(options.Lb, options.Balancer)
+	for i := 0; i < options.LoopNum; i++ {
+		loop, err := newLoop(i, options, &c.eventHandler)
+		if err != nil {
+			_ = c.loopGroup.stopAll()
+			return nil, err
+		}
+		c.loopGroup.register(loop)
+	}
+	return c, nil
+}
+
+func (cli *client) Start() error {
+	if !atomic.CompareAndSwapInt32(&cli.started, 0, 1) {
+		return nil
+	}
+	for _, loop := range cli.loopGroup.loops {
+		loop := loop
+		cli.wg.Add(1)
+		go func() {
+			if err := loop.poll(); err != nil {
+				log.Printf("run loop error, %v \n", err)
+			}
+			if err := loop.Close(); err != nil {
+				log.Printf("close loop error, %v \n", err)
+			}
+			cli.wg.Done()
+		}()
+	}
+	return nil
+}
+
+func (cli *client) Stop() error {
+	cli.once.Do(func() {
+		if atomic.LoadInt32(&cli.started) == 0 {
+			_ = cli.loopGroup.stopAll()
+			return
+		}
+		for _, loop := range cli.loopGroup.loops {
+			if err := loop.epoll.Trigger(loop.forceClose, nil); err != nil && err != errors.ErrEventLoopClosed {
+				log.Printf("trigger force close error, %v \n", err)
+			}
+		}
+		cli.wg.Wait()
+	})
+	return nil
+}
+
+func (cli *client) Dial(network, addr string) (Conn, error) {
+	return cli.DialContext(context.Background(), network, addr)
+}
+
+const (
+	dialPending  int32 = iota
+	dialDone           // callback 已经把结果交给 DialContext
+	dialCanceled       // ctx 已经结束，之后建立的连接直接关闭
+)
+
+func (cli *client) DialContext(ctx context.Context, network, addr string) (Conn, error) {
+	type result struct {
+		c   Conn
+		err error
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ch := make(chan result, 1)
+	var state int32
+	if err := cli.AsyncDial(network, addr, func(c Conn, err error) {
+		if atomic.CompareAndSwapInt32(&state, dialPending, dialDone) {
+			ch <- result{c, err}
+			return
+		}
+		// 在 eventloop 中回调，可以直接关闭
+		if c != nil {
+			_ = c.Close()
+		}
+	}); err != nil {
+		return nil, err
+	}
+	select {
+	case res := <-ch:
+		return res.c, res.err
+	case <-ctx.Done():
+	}
+	if !atomic.CompareAndSwapInt32(&state, dialPending, dialCanceled) {
+		// ctx 结束的同时连接已经完成，结果马上就会写入 ch
+		res := <-ch
+		return res.c, res.err
+	}
+	return nil, ctx.Err()
+}
+
+func (cli *client) AsyncDial(network, addr string, callback func(c Conn, err error)) error {
+	if atomic.LoadInt32(&cli.started) == 0 {
+		return errors.ErrClientNotStarted
+	}
+	if !needResolve(network, addr) {
+		return cli.connect(network, addr, callback)
+	}
+	// DNS 解析可能阻塞很久，不能阻塞调用方（可能是 eventloop）
+	go func() {
+		if err := cli.connect(network, addr, callback); err != nil {
+			cli.dialFailed(callback, err)
+		}
+	}()
+	return nil
+}
+
+// needResolve addr 的 host 不是 IP 时需要 DNS 解析
+func needResolve(network, addr string) bool {
+	if network == "unix" {
+		return false
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		// 格式错误，由 SocketConnect 返回错误
+		return false
+	}
+	if i := strings.LastIndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+	return host != "" && net.ParseIP(host) == nil
+}
+
+// dialFailed 异步解析或者连接失败，在 eventloop 中回调 callback，client 已经关闭时直接在当前 goroutine 中回调
+func (cli *client) dialFailed(callback func(c Conn, err error), err error) {
+	if callback == nil {
+		return
+	}
+	loop := cli.loopGroup.next(nil)
+	if terr := loop.epoll.Trigger(func(interface{}) error {
+		callback(nil, err)
+		return nil
+	}, nil); terr != nil {
+		callback(nil, err)
+	}
+}
+
+// connect 发起非阻塞 connect 并将连接交给 eventloop 监听连接结果
+func (cli *client) connect(network, addr string, callback func(c Conn, err error)) error {
+	fd, sa, raddr, err := internal.SocketConnect(network, addr)
+	if err != nil {
+		return err
+	}
+
+	loop := cli.loopGroup.next(raddr)
+	conn := newConnection(fd, sa, raddr, loop)
+	conn.connecting = true
+	conn.onConnect = callback
+	atomic.AddUint64(&loop.conncnt, 1)
+	if err := loop.epoll.Trigger(loop.registerConnecting, conn); err != nil {
+		atomic.AddUint64(&loop.conncnt, ^uint64(0))
+		_ = unix.Close(fd)
+		return err
+	}
+	return nil
+}
+
+// registerConnecting 监听正在建立的连接的写事件，套接字可写说明 connect 已经完成（成功或者失败），在 loop 所在的 goroutine 中执行
+func (loop *eventloop) registerConnecting(arg interface{}) error {
+	conn := arg.(*connection)
+	if err := loop.epoll.RegWrite(conn.fd); err != nil {
+		loop.connectFailed(conn, err)
+		return nil
+	}
+	loop.reactor[conn.fd] = conn
+	return nil
+}
+
+// handleConnect 非阻塞 connect 完成，通过 SO_ERROR 判断连接结果
+func (loop *eventloop) handleConnect(c *connection) error {
+	if err := internal.SocketError(c.fd); err != nil {
+		delete(loop.reactor, c.fd)
+		loop.connectFailed(c, err)
+		return nil
+	}
+	if err := loop.epoll.ModRead(c.fd); err != nil {
+		delete(loop.reactor, c.fd)
+		loop.connectFailed(c, err)
+		return nil
+	}
+	c.connecting = false
+	if lsa, err := unix.Getsockname(c.fd); err == nil {
+		c.localAddr = internal.SockaddrToTCPOrUnixAddr(lsa)
+	}
+	loop.opened(c)
+
+	callback := c.onConnect
+	c.onConnect = nil
+	if callback != nil {
+		callback(c, nil)
+	}
+	return nil
+}
+
+// connectFailed 连接失败，连接没有建立过，不回调 OnClose
+func (loop *eventloop) connectFailed(c *connection, err error) {
+	atomic.AddUint64(&loop.conncnt, ^uint64(0))
+	c.closed = true
+	_ = unix.Close(c.fd)
+	if c.onConnect != nil {
+		c.onConnect(nil, err)
+	}
+}
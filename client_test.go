@@ -1,39 +1,191 @@
 package jinx
 
 import (
+	"context"
+	"github.com/imlgw/jinx/errors"
+	"golang.org/x/sys/unix"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestClient(t *testing.T) {
-	// fmt.Println("client start...")
-	// // 1.连接远程服务器
-	// conn, err := net.Dial("tcp", "127.0.0.1:8999")
-	// if err != nil {
-	// 	fmt.Println("link err", err)
-	// 	return
-	// }
-	// for {
-	// 	lengthFieldCodec := codec.NewLengthFieldCodec(
-	// 		binary.LittleEndian,
-	// 		0,
-	// 		2,
-	// 		0,
-	// 		2,
-	// 		false,
-	// 	)
-	// 	data := []byte("imlgw.top")
-	// 	encoded, err := lengthFieldCodec.Encode(data)
-	// 	// 2.调用write写数据
-	// 	if _, err := conn.Write(encoded); err != nil {
-	// 		fmt.Println("write err", err)
-	// 		return
-	// 	}
-	// 	// 3.读取服务端返回的内容
-	// 	res, err := lengthFieldCodec.Decode(conn)
-	// 	if err != nil {
-	// 		fmt.Println("read buf err", err)
-	// 	}
-	// 	fmt.Printf("tcpserver return: %s\n", res)
-	// 	time.Sleep(1 * time.Second)
-	// }
+	addr := "127.0.0.1:9896"
+	srv := startEchoServer(t, addr)
+
+	cli, err := NewClient(WithLoopNum(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened := make(chan Conn, 1)
+	replies := make(chan string, 1)
+	closed := make(chan struct{}, 1)
+	cli.OnOpen(func(c Conn) { opened <- c })
+	cli.OnRead(func(c Conn) {
+		buf := make([]byte, 64)
+		n, _ := c.Read(buf)
+		replies <- string(buf[:n])
+	})
+	cli.OnClose(func(c Conn) { closed <- struct{}{} })
+
+	if _, err := cli.Dial("tcp", addr); err != errors.ErrClientNotStarted {
+		t.Fatalf("expected ErrClientNotStarted, got %v", err)
+	}
+	if err := cli.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Stop()
+
+	conn, err := cli.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := <-opened; c != conn {
+		t.Fatal("OnOpen should be called with the dialed conn")
+	}
+	if conn.RemoteAddr().String() != addr || conn.LocalAddr() == nil {
+		t.Fatalf("unexpected addr, local %v remote %v", conn.LocalAddr(), conn.RemoteAddr())
+	}
+
+	// 连接属于 client 的 eventloop，需要通过 AsyncWrite 写入
+	if err := conn.AsyncWrite([]byte("imlgw.top"), nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-replies:
+		if msg != "imlgw.top" {
+			t.Fatalf("unexpected reply %q", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no reply from server")
+	}
+
+	// 服务端关闭后 client 收到 OnClose
+	_ = srv.Stop()
+	select {
+	case <-closed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("OnClose not called")
+	}
+}
+
+func TestClientAsyncDial(t *testing.T) {
+	addr := "127.0.0.1:9897"
+	startEchoServer(t, addr)
+
+	cli, err := NewClient(WithLoopNum(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Stop()
+
+	const n = 200
+	results := make(chan error, n)
+	for i := 0; i < n; i++ {
+		if err := cli.AsyncDial("tcp", addr, func(c Conn, err error) {
+			results <- err
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case err := <-results:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d connections completed", i, n)
+		}
+	}
+}
+
+func TestClientDialRefused(t *testing.T) {
+	cli, err := NewClient(WithLoopNum(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var opened bool
+	cli.OnOpen(func(c Conn) { opened = true })
+	if err := cli.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Stop()
+
+	// 没有服务监听的端口，connect 失败
+	if _, err := cli.Dial("tcp", "127.0.0.1:9898"); err != unix.ECONNREFUSED {
+		t.Fatalf("expected ECONNREFUSED, got %v", err)
+	}
+	if opened {
+		t.Fatal("OnOpen should not be called when connect fails")
+	}
+}
+
+func TestClientConcurrentDial(t *testing.T) {
+	addr := "127.0.0.1:9914"
+	startEchoServer(t, addr)
+
+	// 一致性哈希在第一次选择 loop 时构建哈希环，多个 goroutine 同时 Dial 不能出现数据竞争
+	cli, err := NewClient(WithLoopNum(4), WithLb(ConsistentHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Stop()
+
+	const goroutines, perGoroutine = 8, 10
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines*perGoroutine)
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < perGoroutine; j++ {
+				// 一半的连接需要 DNS 解析
+				target := addr
+				if (i+j)%2 == 0 {
+					target = "localhost:9914"
+				}
+				if _, err := cli.Dial("tcp", target); err != nil {
+					errs <- err
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestClientDialContext(t *testing.T) {
+	addr := "127.0.0.1:9915"
+	startEchoServer(t, addr)
+
+	cli, err := NewClient(WithLoopNum(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if _, err := cli.DialContext(ctx, "tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cli.DialContext(canceled, "tcp", addr); err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
 }
@@ -0,0 +1,110 @@
+package codec
+
+import (
+	"github.com/imlgw/jinx/errors"
+	"io"
+	"net"
+)
+
+// Buffer 解码使用的输入缓冲区，例如连接的 inBuffer。解码只会 Peek 数据，由调用方根据 consumed 消费
+type Buffer interface {
+	// Len 可读的数据长度
+	Len() int
+
+	// Peek 返回前 n 个字节但不消费，n <= 0 时返回全部数据，数据不足 n 时返回已有数据以及 io.ErrShortBuffer。
+	// 返回的切片只在下一次 Peek 或者消费数据之前有效
+	Peek(n int) ([]byte, error)
+}
+
+// ScanBuffer 可选接口，Buffer 实现时按照分隔符解码的编解码器会记录已经扫描过的长度，
+// 数据分多次到达时只查找新增的部分，避免每次从头查找。解码出帧（consumed > 0）时编解码器会将其清零，
+// 调用方在编解码器之外消费数据时需要自己清零
+type ScanBuffer interface {
+	Buffer
+
+	// Scanned 已经扫描过的长度
+	Scanned() int
+
+	// SetScanned 记录已经扫描过的长度
+	SetScanned(n int)
+}
+
+// FrameCodec 增量、非阻塞的编解码器，可以直接在 eventloop 中对 inBuffer 解码
+type FrameCodec interface {
+	Encode(data []byte) ([]byte, error)
+
+	// DecodeFrame 从 buf 中解码一个完整的帧，consumed 为这个帧在 buf 中占用的字节数（包括被跳过的字节）。
+	// 数据不足一个帧时返回 errors.ErrIncomplete。
+	// 返回 errors.ErrTooLongFrame 且 consumed > 0 时，调用方丢弃 consumed 个字节（可能超过 buf 中已有的数据）后可以继续解码，
+	// 其他错误说明数据无法解码，应该关闭连接
+	DecodeFrame(buf Buffer) (frame []byte, consumed int, err error)
+}
+
+// Bytes 将 []byte 包装为 Buffer
+type Bytes []byte
+
+func (b Bytes) Len() int { return len(b) }
+
+func (b Bytes) Peek(n int) ([]byte, error) {
+	if n <= 0 {
+		return b, nil
+	}
+	if n > len(b) {
+		return b, io.ErrShortBuffer
+	}
+	return b[:n], nil
+}
+
+// connBuffer 阻塞读取 net.Conn 的 Buffer，记录解码时需要的数据长度，只读取需要的字节，不会读到下一个帧的数据
+type connBuffer struct {
+	buf     Bytes
+	need    int // 最近一次 Peek 数据不足时需要的长度，0 表示未知
+	scanned int // 每次 Decode 使用新的 connBuffer，数据只会增加
+}
+
+func (cb *connBuffer) Len() int { return len(cb.buf) }
+
+func (cb *connBuffer) Scanned() int     { return cb.scanned }
+func (cb *connBuffer) SetScanned(n int) { cb.scanned = n }
+
+func (cb *connBuffer) Peek(n int) ([]byte, error) {
+	b, err := cb.buf.Peek(n)
+	if err != nil {
+		cb.need = n
+	}
+	return b, err
+}
+
+// DecodeConn 将 FrameCodec 适配为阻塞的 ICodec.Decode：从 c 中读取数据直到解码出一个完整的帧。
+// 解码器没有给出需要的长度时（例如按照分隔符解码）每次只读取一个字节
+func DecodeConn(fc FrameCodec, c net.Conn) ([]byte, error) {
+	cb := new(connBuffer)
+	for {
+		cb.need = 0
+		// 只读取了解码需要的数据，consumed 总是等于已读取的长度
+		frame, consumed, err := fc.DecodeFrame(cb)
+		if err == nil {
+			return frame, nil
+		}
+		if err == errors.ErrTooLongFrame && consumed > len(cb.buf) {
+			// 丢弃超长帧剩余的数据，下一次 Decode 从下一个帧开始
+			if _, err := io.CopyN(io.Discard, c, int64(consumed-len(cb.buf))); err != nil {
+				return nil, err
+			}
+			return nil, errors.ErrTooLongFrame
+		}
+		if err != errors.ErrIncomplete {
+			return nil, err
+		}
+
+		n := cb.need - len(cb.buf)
+		if n <= 0 {
+			n = 1
+		}
+		start := len(cb.buf)
+		cb.buf = append(cb.buf, make([]byte, n)...)
+		if _, err := io.ReadFull(c, cb.buf[start:]); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/binary"
 	"fmt"
 	"github.com/imlgw/jinx/errors"
-	"io"
 	"net"
 )
 
@@ -37,6 +36,22 @@ type LengthFieldCodec struct {
 	decodeLengthAdjustment int
 	// lengthFieldOffset 解码时长度字段偏移，数据包头几个字节可能并不是数据长度
 	lengthFieldOffset int
+	// maxFrameLength 帧的最大长度（包括长度字段以及之前的字节），超过时返回 ErrTooLongFrame，<= 0 表示不限制
+	maxFrameLength int
+	// failFast 为 true 时读到长度字段就返回 ErrTooLongFrame，调用方应该关闭连接；
+	// 为 false 时丢弃整个超长的帧之后继续解码下一个帧（discard-until-resync）
+	failFast bool
+}
+
+// LengthFieldOption LengthFieldCodec 的可选配置
+type LengthFieldOption func(lc *LengthFieldCodec)
+
+// WithMaxFrameLength 设置帧的最大长度，参考 netty 的 TooLongFrameException
+func WithMaxFrameLength(maxFrameLength int, failFast bool) LengthFieldOption {
+	return func(lc *LengthFieldCodec) {
+		lc.maxFrameLength = maxFrameLength
+		lc.failFast = failFast
+	}
 }
 
 func NewDefaultLengthFieldCodec() *LengthFieldCodec {
@@ -53,6 +68,7 @@ func NewDefaultLengthFieldCodec() *LengthFieldCodec {
 
 func NewLengthFieldCodec(order binary.ByteOrder, lengthFieldOffset int, lengthFieldLength int,
 	decodeLengthAdjustment int, decodeInitialBytesToStrip int, encodeLengthIncludesLengthFieldLength bool,
+	opts ...LengthFieldOption,
 ) *LengthFieldCodec {
 	codec := &LengthFieldCodec{
 		byteOrder:                             order,
@@ -62,6 +78,9 @@ func NewLengthFieldCodec(order binary.ByteOrder, lengthFieldOffset int, lengthFi
 		decodeInitialBytesToStrip:             decodeInitialBytesToStrip,
 		encodeLengthIncludesLengthFieldLength: encodeLengthIncludesLengthFieldLength,
 	}
+	for _, opt := range opts {
+		opt(codec)
+	}
 	return codec
 }
 
@@ -96,9 +115,7 @@ func (lc *LengthFieldCodec) Encode(data []byte) ([]byte, error) {
 		out = make([]byte, 4)
 		lc.byteOrder.PutUint32(out, uint32(length))
 	case 8:
-		if uint64(length) > uint64(1<<64-1) {
-			return nil, fmt.Errorf("more than 8 byte in length, %d", length)
-		}
+		out = make([]byte, 8)
 		lc.byteOrder.PutUint64(out, uint64(length))
 	default:
 		return nil, errors.ErrUnsupportedLength
@@ -108,78 +125,70 @@ func (lc *LengthFieldCodec) Encode(data []byte) ([]byte, error) {
 	return append(out, data...), nil
 }
 
-func readN(c net.Conn, n int) ([]byte, error) {
-	var buf = make([]byte, n)
-	// c.Read()可能会超时，可能是隐藏的问题
-	if _, err := io.ReadFull(c, buf); err != nil {
-		return nil, err
-	}
-	return buf, nil
+// Decode 阻塞读取 c 直到解码出一个完整的帧，兼容 ICodec
+func (lc *LengthFieldCodec) Decode(c net.Conn) ([]byte, error) {
+	return DecodeConn(lc, c)
 }
 
-func (lc *LengthFieldCodec) Decode(c net.Conn) ([]byte, error) {
-	var (
-		err    error
-		header []byte
-	)
-	if lc.lengthFieldOffset > 0 {
-		header, err = readN(c, lc.lengthFieldOffset)
-		if err != nil {
-			return nil, err
-		}
+// DecodeFrame 增量解码：先 Peek 帧头获取长度，数据不足时返回 ErrIncomplete，不会阻塞
+func (lc *LengthFieldCodec) DecodeFrame(buf Buffer) ([]byte, int, error) {
+	headerLength := lc.lengthFieldOffset + lc.lengthFieldLength
+	header, err := buf.Peek(headerLength)
+	if err != nil {
+		return nil, 0, errors.ErrIncomplete
 	}
 
-	lenField, length, err := lc.getUnadjustedFrameLength(c)
+	length, err := lc.getUnadjustedFrameLength(header[lc.lengthFieldOffset:])
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	// todo: 超大数据支持. 这里将length转成int, 传输超大payload肯定会丢失数据.
-	//       所以实际上框架目前并不支持超大数据传输(感觉也没有必要，net.Conn一次Read返回的数据长度也是int)
+	// 长度字段不可信，先检查再转换为 int，避免恶意的长度导致溢出或者分配超大的内存
+	if length > uint64(maxInt-headerLength) {
+		return nil, 0, errors.ErrTooLongFrame
+	}
 	// adjusted frame length
 	frameLength := int(length) + lc.decodeLengthAdjustment
+	if frameLength < 0 {
+		return nil, 0, errors.ErrCorruptedFrame
+	}
 
-	frame, err := readN(c, frameLength)
+	total := headerLength + frameLength
+	if lc.maxFrameLength > 0 && total > lc.maxFrameLength {
+		if lc.failFast {
+			return nil, 0, errors.ErrTooLongFrame
+		}
+		// 调用方丢弃 total 个字节（可能超过 buf 中已有的数据）之后重新同步到下一个帧
+		return nil, total, errors.ErrTooLongFrame
+	}
+	if lc.decodeInitialBytesToStrip > total {
+		return nil, 0, errors.ErrCorruptedFrame
+	}
+	data, err := buf.Peek(total)
 	if err != nil {
-		return nil, err
+		return nil, 0, errors.ErrIncomplete
 	}
 
-	msg := make([]byte, len(header)+len(lenField)+frameLength)
-	copy(msg, header)
-	copy(msg[len(header):], lenField)
-	copy(msg[(len(header)+len(lenField)):], frame)
-
-	return msg[lc.decodeInitialBytesToStrip:], err
+	// buf 中的数据在消费之后会被覆盖，需要拷贝
+	msg := make([]byte, total-lc.decodeInitialBytesToStrip)
+	copy(msg, data[lc.decodeInitialBytesToStrip:])
+	return msg, total, nil
 }
 
+const maxInt = int(^uint(0) >> 1)
+
 // 获取未调整前原始的数据帧长度( LengthField 中指定的长度)
-func (lc *LengthFieldCodec) getUnadjustedFrameLength(c net.Conn) ([]byte, uint64, error) {
+func (lc *LengthFieldCodec) getUnadjustedFrameLength(lenField []byte) (uint64, error) {
 	switch lc.lengthFieldLength {
 	case 1:
-		lenField, err := readN(c, 1)
-		if err != nil {
-			return nil, 0, err
-		}
-		return lenField, uint64(lenField[0]), nil
+		return uint64(lenField[0]), nil
 	case 2:
-		lenField, err := readN(c, 2)
-		if err != nil {
-			return nil, 0, err
-		}
-		return lenField, uint64(lc.byteOrder.Uint16(lenField)), nil
+		return uint64(lc.byteOrder.Uint16(lenField)), nil
 	case 4:
-		lenField, err := readN(c, 4)
-		if err != nil {
-			return nil, 0, err
-		}
-		return lenField, uint64(lc.byteOrder.Uint32(lenField)), nil
+		return uint64(lc.byteOrder.Uint32(lenField)), nil
 	case 8:
-		lenField, err := readN(c, 8)
-		if err != nil {
-			return nil, 0, err
-		}
-		return lenField, lc.byteOrder.Uint64(lenField), nil
+		return lc.byteOrder.Uint64(lenField), nil
 	default:
-		return nil, 0, errors.ErrUnsupportedLength
+		return 0, errors.ErrUnsupportedLength
 	}
 }
@@ -3,7 +3,7 @@ package codec
 import (
 	"bytes"
 	"encoding/binary"
-	"fmt"
+	"github.com/imlgw/jinx/errors"
 	"math/rand"
 	"net"
 	"testing"
@@ -75,42 +75,129 @@ func TestNewLengthFieldCodec(t *testing.T) {
 		true,
 	)
 
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpListener.Close()
+
+	imlgwSite := "imlgw.top"
 	go func() {
-		addr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:8999")
-		tcpListener, _ := net.ListenTCP("tcp", addr)
-		for {
-			tcpConn, _ := tcpListener.AcceptTCP()
-			for {
-				decoded, err := codec.Decode(tcpConn)
-				if err != nil {
-					fmt.Println(err)
-					return
-				}
-				fmt.Println("server receive:", string(decoded))
-			}
-		}
-	}()
-	time.Sleep(3 * time.Second)
-	go func() {
-		conn, err := net.Dial("tcp", "127.0.0.1:8999")
+		conn, err := net.Dial("tcp", tcpListener.Addr().String())
 		if err != nil {
-			fmt.Println("link err", err)
+			t.Error("link err", err)
 			return
 		}
+		defer conn.Close()
 
 		sendData := make([]byte, 0)
-
-		imlgwSite := "imlgw.top"
 		encoded, _ := codec.Encode([]byte(imlgwSite))
-
 		for i := 0; i < 20; i++ {
 			sendData = append(sendData, encoded...)
 		}
+		if _, err := conn.Write(sendData); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	tcpConn, err := tcpListener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+	_ = tcpConn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for i := 0; i < 20; i++ {
+		decoded, err := codec.Decode(tcpConn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decoded) != imlgwSite {
+			t.Fatalf("server receive: %s", decoded)
+		}
+	}
+}
 
-		write, err := conn.Write(sendData)
-		fmt.Println("client send:", sendData)
-		fmt.Println(write)
+func TestLengthFieldCodec_DecodeFrame(t *testing.T) {
+	// | header(1) | length(4) | body |，解码后跳过 header
+	codec := NewLengthFieldCodec(binary.BigEndian, 1, 4, 0, 1, false)
+	frame := []byte{0xff, 0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o'}
+	data := append(append([]byte{}, frame...), frame...)
+
+	// 数据逐字节到达，不足一个帧时返回 ErrIncomplete
+	for i := 0; i < len(frame); i++ {
+		if _, _, err := codec.DecodeFrame(Bytes(data[:i])); err != errors.ErrIncomplete {
+			t.Fatalf("expected ErrIncomplete with %d bytes, got %v", i, err)
+		}
+	}
+
+	msg, consumed, err := codec.DecodeFrame(Bytes(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if consumed != len(frame) || !bytes.Equal(msg, []byte{0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o'}) {
+		t.Fatalf("unexpected frame %v, consumed %d", msg, consumed)
+	}
+
+	// 长度修正后为负数
+	codec = NewLengthFieldCodec(binary.BigEndian, 0, 1, -2, 0, false)
+	if _, _, err := codec.DecodeFrame(Bytes{1, 'a'}); err != errors.ErrCorruptedFrame {
+		t.Fatalf("expected ErrCorruptedFrame, got %v", err)
+	}
+}
+
+func TestLengthFieldCodec8_Encode(t *testing.T) {
+	codec := NewLengthFieldCodec(binary.BigEndian, 0, 8, 0, 8, false)
+	out, err := codec.Encode([]byte("imlgw.top"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, _, err := codec.DecodeFrame(Bytes(out))
+	if err != nil || string(msg) != "imlgw.top" {
+		t.Fatalf("unexpected decoded %q, %v", msg, err)
+	}
+}
+
+func TestLengthFieldCodec_MaxFrameLength(t *testing.T) {
+	// 8 字节的长度字段，恶意的长度不会导致分配内存
+	codec := NewLengthFieldCodec(binary.BigEndian, 0, 8, 0, 8, false, WithMaxFrameLength(1024, true))
+	huge := Bytes{0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	if _, consumed, err := codec.DecodeFrame(huge); err != errors.ErrTooLongFrame || consumed != 0 {
+		t.Fatalf("expected ErrTooLongFrame, got %v, consumed %d", err, consumed)
+	}
+	overflow := Bytes{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	if _, _, err := NewLengthFieldCodec(binary.BigEndian, 0, 8, 0, 8, false).DecodeFrame(overflow); err != errors.ErrTooLongFrame {
+		t.Fatalf("expected ErrTooLongFrame without max frame length, got %v", err)
+	}
+
+	// 非 failFast 返回需要丢弃的长度，长度字段读到之后就返回，不需要等待整个帧
+	codec = NewLengthFieldCodec(binary.BigEndian, 0, 2, 0, 2, false, WithMaxFrameLength(16, false))
+	long, _ := codec.Encode(make([]byte, 100))
+	if _, consumed, err := codec.DecodeFrame(Bytes(long[:2])); err != errors.ErrTooLongFrame || consumed != len(long) {
+		t.Fatalf("expected ErrTooLongFrame with %d bytes to discard, got %v, %d", len(long), err, consumed)
+	}
+}
+
+func TestLengthFieldCodec_DiscardResync(t *testing.T) {
+	codec := NewLengthFieldCodec(binary.BigEndian, 0, 2, 0, 2, false, WithMaxFrameLength(16, false))
+	long, _ := codec.Encode(make([]byte, 100))
+	short, _ := codec.Encode([]byte("imlgw.top"))
+
+	server, client := net.Pipe()
+	defer server.Close()
+	go func() {
+		_, _ = client.Write(append(long, short...))
+		_ = client.Close()
 	}()
 
-	select {}
+	// 丢弃超长的帧之后可以继续解码下一个帧
+	if _, err := codec.Decode(server); err != errors.ErrTooLongFrame {
+		t.Fatalf("expected ErrTooLongFrame, got %v", err)
+	}
+	msg, err := codec.Decode(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "imlgw.top" {
+		t.Fatalf("unexpected frame %q", msg)
+	}
 }
@@ -0,0 +1,129 @@
+package codec
+
+import (
+	"bytes"
+	"github.com/imlgw/jinx/errors"
+	"net"
+)
+
+/*
+  参考 netty 的 DelimiterBasedFrameDecoder 以及 LineBasedFrameDecoder
+*/
+
+// DelimiterBasedFrameCodec 按照分隔符切分帧，有多个分隔符时选择切分出的帧最短的那个
+type DelimiterBasedFrameCodec struct {
+	delimiters [][]byte
+	// maxFrameLength 帧的最大长度（不包括分隔符），<= 0 表示不限制
+	maxFrameLength int
+	// stripDelimiter 解码出的帧是否去掉分隔符
+	stripDelimiter bool
+	// failFast 为 true 时超长的帧返回 consumed == 0，调用方应该关闭连接；
+	// 为 false 时已经收到分隔符的超长帧会被丢弃，继续解码下一个帧。
+	// 注意还没有收到分隔符时无法确定帧的边界，总是按照 failFast 处理
+	failFast bool
+	// maxDelimiterLength 最长的分隔符长度
+	maxDelimiterLength int
+}
+
+// NewDelimiterBasedFrameCodec 创建分隔符编解码器，编码时在数据末尾追加第一个非空的分隔符，没有指定分隔符时使用 "\r\n" 和 "\n"，
+// 指定的分隔符全部为空时返回 errors.ErrEmptyDelimiter
+func NewDelimiterBasedFrameCodec(maxFrameLength int, stripDelimiter bool, failFast bool, delimiters ...[]byte) (*DelimiterBasedFrameCodec, error) {
+	if len(delimiters) == 0 {
+		delimiters = [][]byte{[]byte("\n"), []byte("\r\n")}
+	}
+	codec := &DelimiterBasedFrameCodec{
+		maxFrameLength: maxFrameLength,
+		stripDelimiter: stripDelimiter,
+		failFast:       failFast,
+	}
+	for _, d := range delimiters {
+		if len(d) == 0 {
+			continue
+		}
+		codec.delimiters = append(codec.delimiters, append([]byte(nil), d...))
+		if len(d) > codec.maxDelimiterLength {
+			codec.maxDelimiterLength = len(d)
+		}
+	}
+	if len(codec.delimiters) == 0 {
+		return nil, errors.ErrEmptyDelimiter
+	}
+	return codec, nil
+}
+
+func (dc *DelimiterBasedFrameCodec) Encode(data []byte) ([]byte, error) {
+	delimiter := dc.delimiters[0]
+	out := make([]byte, len(data)+len(delimiter))
+	copy(out, data)
+	copy(out[len(data):], delimiter)
+	return out, nil
+}
+
+// Decode 阻塞读取 c 直到读到分隔符，兼容 ICodec
+func (dc *DelimiterBasedFrameCodec) Decode(c net.Conn) ([]byte, error) {
+	return DecodeConn(dc, c)
+}
+
+func (dc *DelimiterBasedFrameCodec) DecodeFrame(buf Buffer) ([]byte, int, error) {
+	data, _ := buf.Peek(0)
+
+	// 已经扫描过的数据中没有分隔符，只需要从可能是分隔符前缀的位置开始查找
+	sb, _ := buf.(ScanBuffer)
+	start := 0
+	if sb != nil {
+		if start = sb.Scanned() - dc.maxDelimiterLength + 1; start < 0 || start > len(data) {
+			start = 0
+		}
+	}
+
+	// 找到最先出现的分隔符，即切分出的帧最短
+	frameLength, delimiterLength := -1, 0
+	for _, d := range dc.delimiters {
+		idx := bytes.Index(data[start:], d)
+		if idx >= 0 && (frameLength < 0 || start+idx < frameLength) {
+			frameLength, delimiterLength = start+idx, len(d)
+		}
+	}
+
+	if frameLength < 0 {
+		if sb != nil {
+			sb.SetScanned(len(data))
+		}
+		// 还没有收到分隔符，已有的数据（去掉可能是分隔符前缀的部分）已经超过最大长度
+		if dc.maxFrameLength > 0 && len(data)-dc.maxDelimiterLength+1 > dc.maxFrameLength {
+			return nil, 0, errors.ErrTooLongFrame
+		}
+		return nil, 0, errors.ErrIncomplete
+	}
+
+	consumed := frameLength + delimiterLength
+	if sb != nil {
+		sb.SetScanned(0)
+	}
+	if dc.maxFrameLength > 0 && frameLength > dc.maxFrameLength {
+		if dc.failFast {
+			return nil, 0, errors.ErrTooLongFrame
+		}
+		return nil, consumed, errors.ErrTooLongFrame
+	}
+
+	if !dc.stripDelimiter {
+		frameLength = consumed
+	}
+	// buf 中的数据在消费之后会被覆盖，需要拷贝
+	frame := make([]byte, frameLength)
+	copy(frame, data)
+	return frame, consumed, nil
+}
+
+// LineBasedFrameCodec 按照 "\n" 或者 "\r\n" 切分帧，编码时追加 "\n"
+type LineBasedFrameCodec struct {
+	*DelimiterBasedFrameCodec
+}
+
+// NewLineBasedFrameCodec 创建按行切分的编解码器，maxLength 为一行的最大长度（不包括换行符）
+func NewLineBasedFrameCodec(maxLength int, stripDelimiter bool, failFast bool) *LineBasedFrameCodec {
+	// 使用默认的分隔符，不会返回错误
+	dc, _ := NewDelimiterBasedFrameCodec(maxLength, stripDelimiter, failFast)
+	return &LineBasedFrameCodec{dc}
+}
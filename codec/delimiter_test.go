@@ -0,0 +1,141 @@
+package codec
+
+import (
+	"github.com/imlgw/jinx/errors"
+	"net"
+	"testing"
+)
+
+func TestLineBasedFrameCodec(t *testing.T) {
+	codec := NewLineBasedFrameCodec(16, true, false)
+	data := Bytes("GET /\r\nPING\nhalf")
+
+	frame, consumed, err := codec.DecodeFrame(data)
+	if err != nil || string(frame) != "GET /" || consumed != 7 {
+		t.Fatalf("unexpected frame %q, consumed %d, %v", frame, consumed, err)
+	}
+	data = data[consumed:]
+	frame, consumed, err = codec.DecodeFrame(data)
+	if err != nil || string(frame) != "PING" || consumed != 5 {
+		t.Fatalf("unexpected frame %q, consumed %d, %v", frame, consumed, err)
+	}
+	data = data[consumed:]
+	if _, _, err := codec.DecodeFrame(data); err != errors.ErrIncomplete {
+		t.Fatalf("expected ErrIncomplete, got %v", err)
+	}
+
+	// 保留分隔符
+	codec = NewLineBasedFrameCodec(16, false, false)
+	if frame, _, _ := codec.DecodeFrame(Bytes("PING\r\n")); string(frame) != "PING\r\n" {
+		t.Fatalf("unexpected frame %q", frame)
+	}
+	if out, _ := codec.Encode([]byte("PONG")); string(out) != "PONG\n" {
+		t.Fatalf("unexpected encoded %q", out)
+	}
+}
+
+func TestDelimiterBasedFrameCodec_TooLongFrame(t *testing.T) {
+	codec, err := NewDelimiterBasedFrameCodec(4, true, false, []byte("$$"), []byte("#"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 多个分隔符时选择最短的帧
+	frame, consumed, err := codec.DecodeFrame(Bytes("ab#cd$$"))
+	if err != nil || string(frame) != "ab" || consumed != 3 {
+		t.Fatalf("unexpected frame %q, consumed %d, %v", frame, consumed, err)
+	}
+
+	// 已经收到分隔符的超长帧返回需要丢弃的长度
+	if _, consumed, err := codec.DecodeFrame(Bytes("abcdef$$gh#")); err != errors.ErrTooLongFrame || consumed != 8 {
+		t.Fatalf("expected ErrTooLongFrame with 8 bytes to discard, got %v, %d", err, consumed)
+	}
+	// 分隔符可能只收到了一部分，不算超长
+	if _, _, err := codec.DecodeFrame(Bytes("abcd$")); err != errors.ErrIncomplete {
+		t.Fatalf("expected ErrIncomplete, got %v", err)
+	}
+	// 没有分隔符时无法确定帧的边界
+	if _, consumed, err := codec.DecodeFrame(Bytes("abcdef")); err != errors.ErrTooLongFrame || consumed != 0 {
+		t.Fatalf("expected ErrTooLongFrame, got %v, %d", err, consumed)
+	}
+
+	codec, err = NewDelimiterBasedFrameCodec(4, true, true, []byte("#"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, consumed, err := codec.DecodeFrame(Bytes("abcdef#")); err != errors.ErrTooLongFrame || consumed != 0 {
+		t.Fatalf("expected fail fast ErrTooLongFrame, got %v, %d", err, consumed)
+	}
+}
+
+func TestDelimiterBasedFrameCodec_Decode(t *testing.T) {
+	codec, err := NewDelimiterBasedFrameCodec(0, true, false, []byte("\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, client := net.Pipe()
+	defer server.Close()
+	go func() {
+		_, _ = client.Write([]byte("SET k v\r\nGET k\r\n"))
+		_ = client.Close()
+	}()
+
+	for _, want := range []string{"SET k v", "GET k"} {
+		frame, err := codec.Decode(server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(frame) != want {
+			t.Fatalf("expected %q, got %q", want, frame)
+		}
+	}
+}
+
+func TestDelimiterBasedFrameCodec_EmptyDelimiter(t *testing.T) {
+	if _, err := NewDelimiterBasedFrameCodec(0, true, false, []byte{}, nil); err != errors.ErrEmptyDelimiter {
+		t.Fatalf("expected ErrEmptyDelimiter, got %v", err)
+	}
+}
+
+// scanBytes 记录扫描位置的 Buffer，模拟连接的 inBuffer
+type scanBytes struct {
+	Bytes
+	scanned int
+}
+
+func (b *scanBytes) Scanned() int     { return b.scanned }
+func (b *scanBytes) SetScanned(n int) { b.scanned = n }
+
+func TestDelimiterBasedFrameCodec_Incremental(t *testing.T) {
+	codec, err := NewDelimiterBasedFrameCodec(0, true, false, []byte("\r\n"), []byte("$$$"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 每次只到达一个字节，分隔符跨越两次到达的数据
+	input := "SET k v\r\nGET k$$$PING\r\n"
+	buf := new(scanBytes)
+	var frames []string
+	for i := 0; i < len(input); i++ {
+		buf.Bytes = append(buf.Bytes, input[i])
+		for {
+			frame, consumed, err := codec.DecodeFrame(buf)
+			if err == errors.ErrIncomplete {
+				if buf.scanned != len(buf.Bytes) {
+					t.Fatalf("expected scanned %d, got %d", len(buf.Bytes), buf.scanned)
+				}
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.scanned != 0 {
+				t.Fatalf("scanned should be reset after decoding a frame, got %d", buf.scanned)
+			}
+			frames = append(frames, string(frame))
+			buf.Bytes = buf.Bytes[consumed:]
+		}
+	}
+	if len(frames) != 3 || frames[0] != "SET k v" || frames[1] != "GET k" || frames[2] != "PING" {
+		t.Fatalf("unexpected frames %q", frames)
+	}
+}
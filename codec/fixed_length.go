@@ -0,0 +1,47 @@
+package codec
+
+import (
+	"github.com/imlgw/jinx/errors"
+	"net"
+)
+
+/*
+  参考 netty 的 FixedLengthFrameDecoder
+*/
+
+// FixedLengthFrameCodec 每个帧都是固定的长度，没有帧头
+type FixedLengthFrameCodec struct {
+	frameLength int
+}
+
+// NewFixedLengthFrameCodec 创建固定长度的编解码器，frameLength <= 0 时返回 errors.ErrInvalidFrameLength
+func NewFixedLengthFrameCodec(frameLength int) (*FixedLengthFrameCodec, error) {
+	if frameLength <= 0 {
+		return nil, errors.ErrInvalidFrameLength
+	}
+	return &FixedLengthFrameCodec{frameLength: frameLength}, nil
+}
+
+// Encode 数据长度必须等于 frameLength
+func (fc *FixedLengthFrameCodec) Encode(data []byte) ([]byte, error) {
+	if len(data) != fc.frameLength {
+		return nil, errors.ErrFrameLengthMismatch
+	}
+	return data, nil
+}
+
+// Decode 阻塞读取 c 直到读满一个帧，兼容 ICodec
+func (fc *FixedLengthFrameCodec) Decode(c net.Conn) ([]byte, error) {
+	return DecodeConn(fc, c)
+}
+
+func (fc *FixedLengthFrameCodec) DecodeFrame(buf Buffer) ([]byte, int, error) {
+	data, err := buf.Peek(fc.frameLength)
+	if err != nil {
+		return nil, 0, errors.ErrIncomplete
+	}
+	// buf 中的数据在消费之后会被覆盖，需要拷贝
+	frame := make([]byte, fc.frameLength)
+	copy(frame, data)
+	return frame, fc.frameLength, nil
+}
@@ -0,0 +1,41 @@
+package codec
+
+import (
+	"github.com/imlgw/jinx/errors"
+	"net"
+	"testing"
+)
+
+func TestFixedLengthFrameCodec(t *testing.T) {
+	if _, err := NewFixedLengthFrameCodec(0); err != errors.ErrInvalidFrameLength {
+		t.Fatalf("expected ErrInvalidFrameLength, got %v", err)
+	}
+	codec, err := NewFixedLengthFrameCodec(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := codec.Encode([]byte("abc")); err != errors.ErrFrameLengthMismatch {
+		t.Fatalf("expected ErrFrameLengthMismatch, got %v", err)
+	}
+	data := Bytes("abcdefg")
+	frame, consumed, err := codec.DecodeFrame(data)
+	if err != nil || string(frame) != "abcd" || consumed != 4 {
+		t.Fatalf("unexpected frame %q, consumed %d, %v", frame, consumed, err)
+	}
+	if _, _, err := codec.DecodeFrame(data[consumed:]); err != errors.ErrIncomplete {
+		t.Fatalf("expected ErrIncomplete, got %v", err)
+	}
+
+	server, client := net.Pipe()
+	defer server.Close()
+	go func() {
+		_, _ = client.Write([]byte("abcdefgh"))
+		_ = client.Close()
+	}()
+	for _, want := range []string{"abcd", "efgh"} {
+		frame, err := codec.Decode(server)
+		if err != nil || string(frame) != want {
+			t.Fatalf("expected %q, got %q, %v", want, frame, err)
+		}
+	}
+}
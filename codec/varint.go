@@ -0,0 +1,80 @@
+package codec
+
+import (
+	"encoding/binary"
+	"github.com/imlgw/jinx/errors"
+	"net"
+)
+
+/*
+  参考 netty 的 ProtobufVarint32FrameDecoder 以及 ProtobufVarint32LengthFieldPrepender，
+  长度前缀为 unsigned LEB128（protobuf 的 varint），与 protobuf delimited 流的格式相同
+*/
+
+// VarintLengthFieldCodec 帧格式为 | varint 长度 | 消息体 |，解码后去掉长度前缀
+type VarintLengthFieldCodec struct {
+	// maxFrameLength 消息体的最大长度（不包括长度前缀），<= 0 表示不限制
+	maxFrameLength int
+	// failFast 与 LengthFieldCodec 相同，为 false 时丢弃超长的帧之后继续解码下一个帧
+	failFast bool
+}
+
+func NewVarintLengthFieldCodec(maxFrameLength int, failFast bool) *VarintLengthFieldCodec {
+	return &VarintLengthFieldCodec{maxFrameLength: maxFrameLength, failFast: failFast}
+}
+
+func (vc *VarintLengthFieldCodec) Encode(data []byte) ([]byte, error) {
+	if vc.maxFrameLength > 0 && len(data) > vc.maxFrameLength {
+		return nil, errors.ErrTooLongFrame
+	}
+	out := make([]byte, binary.MaxVarintLen64+len(data))
+	n := binary.PutUvarint(out, uint64(len(data)))
+	copy(out[n:], data)
+	return out[:n+len(data)], nil
+}
+
+// Decode 阻塞读取 c 直到读到一个完整的帧，兼容 ICodec
+func (vc *VarintLengthFieldCodec) Decode(c net.Conn) ([]byte, error) {
+	return DecodeConn(vc, c)
+}
+
+func (vc *VarintLengthFieldCodec) DecodeFrame(buf Buffer) ([]byte, int, error) {
+	// 只 Peek 已有的数据，不能要求更多的字节，否则 DecodeConn 可能读到下一个帧的数据
+	n := buf.Len()
+	if n > binary.MaxVarintLen64 {
+		n = binary.MaxVarintLen64
+	}
+	prefix, _ := buf.Peek(n)
+	length, n := binary.Uvarint(prefix)
+	if n == 0 {
+		// 最高位都是 1，长度前缀还没有收完。超过 MaxVarintLen64 个字节仍然没有结束说明数据有误
+		if len(prefix) >= binary.MaxVarintLen64 {
+			return nil, 0, errors.ErrMalformedVarint
+		}
+		return nil, 0, errors.ErrIncomplete
+	}
+	if n < 0 {
+		// 超过 64 位
+		return nil, 0, errors.ErrMalformedVarint
+	}
+
+	if length > uint64(maxInt-n) {
+		return nil, 0, errors.ErrTooLongFrame
+	}
+	frameLength := int(length)
+	if vc.maxFrameLength > 0 && frameLength > vc.maxFrameLength {
+		if vc.failFast {
+			return nil, 0, errors.ErrTooLongFrame
+		}
+		return nil, n + frameLength, errors.ErrTooLongFrame
+	}
+
+	data, err := buf.Peek(n + frameLength)
+	if err != nil {
+		return nil, 0, errors.ErrIncomplete
+	}
+	// buf 中的数据在消费之后会被覆盖，需要拷贝
+	frame := make([]byte, frameLength)
+	copy(frame, data[n:])
+	return frame, n + frameLength, nil
+}
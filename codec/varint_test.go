@@ -0,0 +1,87 @@
+package codec
+
+import (
+	"bytes"
+	"github.com/imlgw/jinx/errors"
+	"net"
+	"testing"
+)
+
+func TestVarintLengthFieldCodec(t *testing.T) {
+	codec := NewVarintLengthFieldCodec(1<<20, true)
+	body := bytes.Repeat([]byte("a"), 300)
+	out, err := codec.Encode(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 300 = 0b1_0010_1100 --> 0xac 0x02
+	if out[0] != 0xac || out[1] != 0x02 || len(out) != 302 {
+		t.Fatalf("unexpected prefix % x, len %d", out[:2], len(out))
+	}
+
+	for i := 0; i < len(out); i++ {
+		if _, _, err := codec.DecodeFrame(Bytes(out[:i])); err != errors.ErrIncomplete {
+			t.Fatalf("expected ErrIncomplete with %d bytes, got %v", i, err)
+		}
+	}
+	frame, consumed, err := codec.DecodeFrame(Bytes(append(out, 0x01)))
+	if err != nil || consumed != len(out) || !bytes.Equal(frame, body) {
+		t.Fatalf("unexpected frame, consumed %d, %v", consumed, err)
+	}
+
+	// 空的消息体
+	out, _ = codec.Encode(nil)
+	if frame, consumed, err := codec.DecodeFrame(Bytes(out)); err != nil || consumed != 1 || len(frame) != 0 {
+		t.Fatalf("unexpected empty frame, consumed %d, %v", consumed, err)
+	}
+}
+
+func TestVarintLengthFieldCodec_Malformed(t *testing.T) {
+	codec := NewVarintLengthFieldCodec(0, true)
+	// 超过 10 个字节没有结束
+	if _, _, err := codec.DecodeFrame(Bytes(bytes.Repeat([]byte{0x80}, 11))); err != errors.ErrMalformedVarint {
+		t.Fatalf("expected ErrMalformedVarint, got %v", err)
+	}
+	// 第 10 个字节超过 64 位
+	overflow := append(bytes.Repeat([]byte{0xff}, 9), 0x02)
+	if _, _, err := codec.DecodeFrame(Bytes(overflow)); err != errors.ErrMalformedVarint {
+		t.Fatalf("expected ErrMalformedVarint, got %v", err)
+	}
+	// 长度超过 int
+	huge := append(bytes.Repeat([]byte{0xff}, 9), 0x01)
+	if _, _, err := codec.DecodeFrame(Bytes(huge)); err != errors.ErrTooLongFrame {
+		t.Fatalf("expected ErrTooLongFrame, got %v", err)
+	}
+
+	codec = NewVarintLengthFieldCodec(16, false)
+	if _, err := codec.Encode(make([]byte, 17)); err != errors.ErrTooLongFrame {
+		t.Fatalf("expected ErrTooLongFrame, got %v", err)
+	}
+	long, _ := NewVarintLengthFieldCodec(0, false).Encode(make([]byte, 100))
+	if _, consumed, err := codec.DecodeFrame(Bytes(long[:1])); err != errors.ErrTooLongFrame || consumed != 101 {
+		t.Fatalf("expected ErrTooLongFrame with 101 bytes to discard, got %v, %d", err, consumed)
+	}
+}
+
+func TestVarintLengthFieldCodec_Decode(t *testing.T) {
+	codec := NewVarintLengthFieldCodec(0, true)
+	server, client := net.Pipe()
+	defer server.Close()
+	go func() {
+		for _, msg := range []string{"a", "imlgw.top", ""} {
+			out, _ := codec.Encode([]byte(msg))
+			_, _ = client.Write(out)
+		}
+		_ = client.Close()
+	}()
+	// 短帧不能多读下一个帧的数据
+	for _, want := range []string{"a", "imlgw.top", ""} {
+		frame, err := codec.Decode(server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(frame) != want {
+			t.Fatalf("expected %q, got %q", want, frame)
+		}
+	}
+}
@@ -5,96 +5,353 @@ import (
 	"github.com/imlgw/jinx/errors"
 	"github.com/imlgw/jinx/internal"
 	"golang.org/x/sys/unix"
+	"io"
 	"log"
 	"net"
-	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Conn interface {
 	net.Conn
 	IsOpen() bool
+
+	// AsyncWrite 将 b 投递到连接所属的 eventloop 中写入，可以在任意 goroutine 中调用。
+	// 写入完成（写入内核或者 outBuffer）后在 eventloop 中回调 callback，callback 可以为 nil，
+	// 回调之前不能修改 b。连接已经关闭时 callback 收到 ErrConnClosed
+	AsyncWrite(b []byte, callback func(err error)) error
+
+	// CloseAfterFlush 不再读取数据，outBuffer 中的数据全部写入内核之后关闭连接，例如 HTTP 响应 Connection: close。
+	// 与 Write 一样只能在连接所属的 eventloop 中调用
+	CloseAfterFlush() error
+
+	// Writev 按顺序写入多个缓冲区，数据通过 writev 一次写入内核，不需要先拼接成连续的内存（例如协议头 + 消息体）。
+	// 与 Write 一样只能在连接所属的 eventloop 中调用
+	Writev(bs [][]byte) (int, error)
+
+	// PeerCred 获取 unix domain socket 对端进程的凭证（SO_PEERCRED），可以用来认证本地进程，
+	// 非 unix domain socket 连接返回 ErrUnsupportedOp
+	PeerCred() (*unix.Ucred, error)
+
+	// Peek 返回 inBuffer 中前 n 个字节但不消费，n <= 0 时返回全部数据，数据不足 n 时返回已有数据以及 io.ErrShortBuffer。
+	// 返回的切片只在下一次 Peek/Next 调用或者当前回调结束之前有效
+	Peek(n int) ([]byte, error)
+
+	// Discard 丢弃 inBuffer 中前 n 个字节，返回实际丢弃的长度
+	Discard(n int) (int, error)
+
+	// Next 返回并消费 inBuffer 中前 n 个字节，相当于 Peek + Discard
+	Next(n int) ([]byte, error)
+
+	// InboundBuffered inBuffer 中尚未被消费的数据长度
+	InboundBuffered() int
+
+	// Send 依次经过 pipeline 的各个阶段或者使用 Codec 编码 msg 后写入，都没有配置时直接写入，
+	// 与 Write 一样只能在连接所属的 eventloop 中调用
+	Send(msg []byte) error
+
+	// Pipeline 连接的处理阶段，没有通过 WithPipeline 配置时为空，可以在运行时添加、移除阶段（例如握手完成之后升级协议）
+	Pipeline() *Pipeline
+
+	// AfterFunc d 之后在连接所属的 eventloop 中执行 f，连接已经关闭时不再执行，可以在任意 goroutine 中调用
+	AfterFunc(d time.Duration, f func()) (*Timer, error)
 }
 
 type connection struct {
-	mux        sync.Mutex
 	fd         int
 	sa         unix.Sockaddr
 	loop       *eventloop
 	remoteAddr net.Addr
 	localAddr  net.Addr
-	codec      codec.ICodec // 编解码器
-	outBuffer  []byte       // 写缓存
-	inBuffer   []byte       // 读缓存
+	codec      codec.ICodec          // 编解码器
+	pipeline   *Pipeline             // 处理阶段，不为空时代替 codec
+	outBuffer  internal.LinkedBuffer // 写缓存，由内存池中的块组成
+	inBuffer   *internal.RingBuffer  // 读缓存，未被消费的数据会一直保留
+	discarding int                   // 丢弃超长帧时剩余需要丢弃的字节数
+	scanned    int                   // 分隔符解码已经扫描过的长度，见 codec.ScanBuffer
 	closed     bool
+	closing    bool // CloseAfterFlush 之后等待 outBuffer flush 完成
+
+	// 读写超时以及空闲超时的定时器，由 loop 的定时器堆驱动
+	readTimer  *internal.Timer
+	writeTimer *internal.Timer
+	idleTimer  *internal.Timer
+	// lastActive 最近一次读写数据的时间，UnixNano，开启空闲超时才会更新
+	lastActive int64
+
+	// connecting client 发起的连接正在建立，onConnect 为 AsyncDial 的回调
+	connecting bool
+	onConnect  func(c Conn, err error)
 }
 
 func newConnection(fd int, sa unix.Sockaddr, remoteAddr net.Addr, loop *eventloop) *connection {
-	return &connection{
+	c := &connection{
 		fd:         fd,
 		sa:         sa,
 		remoteAddr: remoteAddr,
 		loop:       loop,
-		inBuffer:   make([]byte, 0xffff),
+		codec:      loop.opts.Codec,
+		inBuffer:   internal.NewRingBuffer(loop.opts.InboundBufferMin, loop.opts.InboundBufferMax),
 	}
+	c.pipeline = newPipeline(c, loop.opts.Pipeline)
+	return c
 }
 
-// Read from client，将 inBuffer 或者内核中的数据写入 b
+// Read from client，将 inBuffer 中的数据写入 b 并消费
 func (c *connection) Read(b []byte) (int, error) {
 	if c.closed {
 		return 0, errors.ErrConnClosed
 	}
-	return copy(b, c.inBuffer), nil
+	c.scanned = 0
+	return c.inBuffer.Read(b)
+}
+
+func (c *connection) Peek(n int) ([]byte, error) {
+	if c.closed {
+		return nil, errors.ErrConnClosed
+	}
+	head, tail := c.inBuffer.Peek(n)
+	var buf []byte
+	if len(tail) == 0 {
+		buf = head
+	} else {
+		// 数据跨越数组末尾，拼接到 loop 共享的 peekBuf 中
+		buf = append(append(c.loop.peekBuf[:0], head...), tail...)
+		c.loop.peekBuf = buf
+	}
+	if n > 0 && len(buf) < n {
+		return buf, io.ErrShortBuffer
+	}
+	return buf, nil
+}
+
+func (c *connection) Discard(n int) (int, error) {
+	if c.closed {
+		return 0, errors.ErrConnClosed
+	}
+	c.scanned = 0
+	return c.inBuffer.Discard(n), nil
+}
+
+func (c *connection) Next(n int) ([]byte, error) {
+	buf, err := c.Peek(n)
+	if err != nil {
+		return buf, err
+	}
+	c.scanned = 0
+	c.inBuffer.Discard(len(buf))
+	return buf, nil
 }
 
-// Write b to client，将 b 中的数据写入 outBuffer 或者内核
+func (c *connection) InboundBuffered() int { return c.inBuffer.Len() }
+
+// Write b to client，将 b 中的数据写入 outBuffer 或者内核。
+// 只能在连接所属的 eventloop 中调用（OnOpen，OnRead 等回调），其他 goroutine 需要使用 AsyncWrite
 func (c *connection) Write(b []byte) (int, error) {
 	if c.closed {
 		return 0, errors.ErrConnClosed
 	}
 
-	// 没有历史数据
-	if len(c.outBuffer) == 0 {
-		writen, err := unix.Write(c.fd, b)
-		if err != nil {
-			return writen, err
+	c.active()
+	// 有历史数据，先写入 outBuffer 等待可写事件，保证数据顺序
+	if !c.outBuffer.IsEmpty() {
+		_, _ = c.outBuffer.Write(b)
+		c.loop.addPending(len(b))
+		return len(b), nil
+	}
+
+	// 没有历史数据，直接写入内核
+	writen, err := unix.Write(c.fd, b)
+	if err != nil && err != unix.EAGAIN {
+		return 0, err
+	}
+	if writen < 0 {
+		writen = 0
+	}
+	if writen < len(b) {
+		// TCP写半包: 没写完，将剩余数据先存入 outBuffer 然后注册读写事件
+		_, _ = c.outBuffer.Write(b[writen:])
+		c.loop.addPending(len(b) - writen)
+		if err := c.loop.epoll.ModReadWrite(c.fd); err != nil {
+			log.Printf("conn write [RegReadWrite] error, %v \n", err)
+			return 0, c.Close()
 		}
+	}
+	return len(b), nil
+}
+
+func (c *connection) Writev(bs [][]byte) (int, error) {
+	if c.closed {
+		return 0, errors.ErrConnClosed
+	}
 
-		if writen <= 0 {
-			writen = 0
+	var size int
+	for _, b := range bs {
+		size += len(b)
+	}
+
+	c.active()
+	// 有历史数据，先写入 outBuffer 等待可写事件，保证数据顺序
+	if !c.outBuffer.IsEmpty() {
+		for _, b := range bs {
+			_, _ = c.outBuffer.Write(b)
 		}
-		if writen < len(b) {
-			// TCP写半包: 没写完，将剩余数据先存入 outBuffer 然后注册读写事件
-			// TODO: 需要一个弹性扩容的结构
-			c.outBuffer = make([]byte, writen)
-			copy(c.outBuffer, b[writen:])
-			if err := c.loop.epoll.ModReadWrite(c.fd); err != nil {
-				log.Printf("conn write [RegReadWrite] error, %v \n", err)
-				return 0, c.Close()
+		c.loop.addPending(size)
+		return size, nil
+	}
+
+	writen, err := internal.Writev(c.fd, bs)
+	if err != nil && err != unix.EAGAIN {
+		return 0, err
+	}
+	if writen < 0 {
+		writen = 0
+	}
+	if writen < size {
+		// 跳过已经写入内核的部分，剩余数据存入 outBuffer 然后注册读写事件
+		remain := writen
+		for _, b := range bs {
+			if remain >= len(b) {
+				remain -= len(b)
+				continue
 			}
+			_, _ = c.outBuffer.Write(b[remain:])
+			remain = 0
+		}
+		c.loop.addPending(size - writen)
+		if err := c.loop.epoll.ModReadWrite(c.fd); err != nil {
+			log.Printf("conn writev [RegReadWrite] error, %v \n", err)
+			return 0, c.Close()
 		}
-		return len(b), nil
 	}
+	return size, nil
+}
 
-	// 有历史数据，先写入 outBuffer 等待可写事件
-	c.outBuffer = append(c.outBuffer, b...)
+func (c *connection) AsyncWrite(b []byte, callback func(err error)) error {
+	// closed 只能在 loop 中访问，连接是否已经关闭由任务中的 Write 判断
+	return c.loop.epoll.Trigger(func(interface{}) error {
+		_, err := c.Write(b)
+		if callback != nil {
+			callback(err)
+		}
+		return nil
+	}, nil)
+}
 
-	return len(b), nil
+func (c *connection) PeerCred() (*unix.Ucred, error) {
+	if c.closed {
+		return nil, errors.ErrConnClosed
+	}
+	if _, ok := c.sa.(*unix.SockaddrUnix); !ok {
+		return nil, errors.ErrUnsupportedOp
+	}
+	return unix.GetsockoptUcred(c.fd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+}
+
+func (c *connection) LocalAddr() net.Addr  { return c.localAddr }
+func (c *connection) RemoteAddr() net.Addr { return c.remoteAddr }
+
+// SetDeadline 同时设置读写超时，与 Write 一样只能在连接所属的 eventloop 中调用
+func (c *connection) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
 }
 
-func (c *connection) LocalAddr() net.Addr                { return c.localAddr }
-func (c *connection) RemoteAddr() net.Addr               { return c.remoteAddr }
-func (c *connection) SetDeadline(t time.Time) error      { return errors.ErrUnsupportedOp }
-func (c *connection) SetReadDeadline(t time.Time) error  { return errors.ErrUnsupportedOp }
-func (c *connection) SetWriteDeadline(t time.Time) error { return errors.ErrUnsupportedOp }
+// SetReadDeadline 在 t 之前没有收到新的数据时回调 OnTimeout 并关闭连接，收到数据后 deadline 失效，t 为零值时取消
+func (c *connection) SetReadDeadline(t time.Time) error {
+	if c.closed {
+		return errors.ErrConnClosed
+	}
+	c.readTimer = c.setTimer(c.readTimer, t, func() error {
+		c.readTimer = nil
+		return c.timeout()
+	})
+	return nil
+}
+
+// SetWriteDeadline 在 t 时 outBuffer 中还有数据没有写入内核时回调 OnTimeout 并关闭连接，outBuffer 清空后 deadline 失效，t 为零值时取消
+func (c *connection) SetWriteDeadline(t time.Time) error {
+	if c.closed {
+		return errors.ErrConnClosed
+	}
+	c.writeTimer = c.setTimer(c.writeTimer, t, func() error {
+		c.writeTimer = nil
+		if c.outBuffer.IsEmpty() {
+			return nil
+		}
+		return c.timeout()
+	})
+	return nil
+}
+
+// setTimer 添加或者修改定时器，t 为零值时停止定时器并返回 nil
+func (c *connection) setTimer(timer *internal.Timer, t time.Time, fn func() error) *internal.Timer {
+	if t.IsZero() {
+		if timer != nil {
+			c.loop.epoll.StopTimer(timer)
+		}
+		return nil
+	}
+	if timer == nil {
+		return c.loop.epoll.AddTimer(t, fn)
+	}
+	c.loop.epoll.ResetTimer(timer, t)
+	return timer
+}
+
+// startIdleTimer 开启空闲超时，连接注册到 loop 时调用
+func (c *connection) startIdleTimer(idle time.Duration) {
+	c.lastActive = time.Now().UnixNano()
+	c.idleTimer = c.loop.epoll.AddTimer(time.Unix(0, c.lastActive).Add(idle), func() error {
+		// 定时器不会随每次读写重置，到期时根据最近活跃时间判断是否真的空闲，没有则顺延
+		deadline := time.Unix(0, c.lastActive).Add(idle)
+		if time.Now().Before(deadline) {
+			c.loop.epoll.ResetTimer(c.idleTimer, deadline)
+			return nil
+		}
+		c.idleTimer = nil
+		return c.timeout()
+	})
+}
+
+// active 记录连接有数据读写
+func (c *connection) active() {
+	if c.idleTimer != nil {
+		c.lastActive = time.Now().UnixNano()
+	}
+}
+
+// timeout 超时回调 OnTimeout 并关闭连接
+func (c *connection) timeout() error {
+	if c.closed {
+		return nil
+	}
+	if c.loop.handler.onTimeout != nil {
+		c.loop.handler.onTimeout(c)
+	}
+	if err := c.Close(); err != nil {
+		return err
+	}
+	// 优雅关闭过程中最后一个连接超时关闭，退出 loop
+	if c.loop.draining && len(c.loop.reactor) == 0 {
+		return errors.ErrServerShutdown
+	}
+	return nil
+}
 
 // handleEvent 作为 reactor 响应 epoll 事件
 func (c *connection) handleEvent(_ int, eventType internal.EventType) error {
+	if c.connecting {
+		return c.loop.handleConnect(c)
+	}
+
 	if eventType&unix.EPOLLIN != 0 {
 		return c.loop.handleReadEvent(c)
 	}
 
-	if eventType&unix.EPOLLOUT != 0 && len(c.outBuffer) != 0 {
+	if eventType&unix.EPOLLOUT != 0 && !c.outBuffer.IsEmpty() {
 		return c.loop.handleWriteEvent(c)
 	}
 	return nil
@@ -102,14 +359,26 @@ func (c *connection) handleEvent(_ int, eventType internal.EventType) error {
 
 // Close 关闭连接
 func (c *connection) Close() error {
-	c.closed = true
-	if c.loop.ser.onClose != nil {
-		c.loop.ser.onClose(c)
+	if c.closed {
+		return nil
+	}
+	if c.connecting {
+		// 连接还没有建立，不回调 OnClose
+		delete(c.loop.reactor, c.fd)
+		c.loop.connectFailed(c, errors.ErrConnClosed)
+		return nil
 	}
+	c.closed = true
+	// 先更新连接数再回调 OnClose，回调中看到的 loop 连接数已经不包括当前连接
 	delete(c.loop.reactor, c.fd)
-	// 关闭连接，不用关闭 loop
-	c.loop = nil
-	c.outBuffer = nil
+	atomic.AddUint64(&c.loop.conncnt, ^uint64(0))
+	if c.loop.handler.onClose != nil {
+		c.loop.handler.onClose(c)
+	}
+	// 关闭连接，不用关闭 loop。保留 loop 引用，已经投递的 AsyncWrite 任务依赖它判断连接状态
+	c.loop.addPending(-c.outBuffer.Len())
+	c.outBuffer.Release()
+	c.stopTimers()
 	// 关闭 connfd
 	if err := unix.Close(c.fd); err != nil {
 		return err
@@ -117,4 +386,26 @@ func (c *connection) Close() error {
 	return nil
 }
 
+func (c *connection) CloseAfterFlush() error {
+	if c.closed {
+		return nil
+	}
+	if c.outBuffer.IsEmpty() {
+		return c.Close()
+	}
+	// 只监听写事件，flush 完成后在 handleWriteEvent 中关闭
+	c.closing = true
+	return c.loop.epoll.ModWrite(c.fd)
+}
+
 func (c *connection) IsOpen() bool { return !c.closed }
+
+// stopTimers 关闭连接时停止所有定时器
+func (c *connection) stopTimers() {
+	for _, t := range []**internal.Timer{&c.readTimer, &c.writeTimer, &c.idleTimer} {
+		if *t != nil {
+			c.loop.epoll.StopTimer(*t)
+			*t = nil
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package jinx
+
+import (
+	"bytes"
+	"github.com/imlgw/jinx/errors"
+	"github.com/imlgw/jinx/internal/testutil"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnAsyncWrite(t *testing.T) {
+	addr := "127.0.0.1:9881"
+	server, err := NewServer("tcp", addr, WithLoopNum(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written := make(chan error, 1)
+	server.OnRead(func(c Conn) {
+		buf := make([]byte, 64)
+		n, _ := c.Read(buf)
+		// 模拟在 eventloop 之外的 goroutine 中处理业务后异步写回
+		go func(msg []byte) {
+			time.Sleep(10 * time.Millisecond)
+			if err := c.AsyncWrite(append([]byte("async:"), msg...), func(err error) {
+				written <- err
+			}); err != nil {
+				written <- err
+			}
+		}(buf[:n])
+	})
+
+	testutil.StartServer(t, server, 3*time.Second)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("async write callback not called")
+	}
+
+	buf := make([]byte, 64)
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "async:ping" {
+		t.Fatalf("unexpected response %q", buf[:n])
+	}
+}
+
+func TestConnAsyncWriteAfterClose(t *testing.T) {
+	addr := "127.0.0.1:9912"
+	server, err := NewServer("tcp", addr, WithLoopNum(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written := make(chan error, 1)
+	server.OnClose(func(c Conn) {
+		// 连接关闭之后在其他 goroutine 中写入，callback 收到 ErrConnClosed
+		go func() {
+			if err := c.AsyncWrite([]byte("late"), func(err error) {
+				written <- err
+			}); err != nil {
+				written <- err
+			}
+		}()
+	})
+
+	testutil.StartServer(t, server, 3*time.Second)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+
+	select {
+	case err := <-written:
+		if err != errors.ErrConnClosed {
+			t.Fatalf("expected ErrConnClosed, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("async write callback not called")
+	}
+}
+
+func TestConnInboundBuffer(t *testing.T) {
+	addr := "127.0.0.1:9889"
+	srv, err := NewServer("tcp", addr, WithLoopNum(1), WithInboundBuffer(16, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := make(chan string, 4)
+	closed := make(chan struct{}, 1)
+	srv.OnRead(func(c Conn) {
+		// 凑够 5 个字节的消息头再处理，不足的数据保留在 inBuffer 中
+		for c.InboundBuffered() >= 5 {
+			head, err := c.Peek(5)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if string(head) == "large" {
+				// 不消费数据，等待超过 inBuffer 上限
+				return
+			}
+			msg, _ := c.Next(5)
+			msgs <- string(msg)
+		}
+		if _, err := c.Peek(5); err != io.ErrShortBuffer {
+			t.Errorf("expected ErrShortBuffer, got %v", err)
+		}
+	})
+	srv.OnClose(func(c Conn) { closed <- struct{}{} })
+	testutil.StartServer(t, srv, 3*time.Second)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, part := range []string{"hel", "lowor", "ld", "abcdefghij"} {
+		if _, err := conn.Write([]byte(part)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	for _, expect := range []string{"hello", "world", "abcde", "fghij"} {
+		select {
+		case msg := <-msgs:
+			if msg != expect {
+				t.Fatalf("expected %q, got %q", expect, msg)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("message %q not received", expect)
+		}
+	}
+
+	// 超过 inBuffer 上限后关闭连接
+	if _, err := conn.Write(append([]byte("large"), make([]byte, 128)...)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("conn not closed after inbound buffer full")
+	}
+}
+
+func TestConnLargeWrite(t *testing.T) {
+	addr := "127.0.0.1:9890"
+	srv, err := NewServer("tcp", addr, WithLoopNum(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 一次写入远超内核发送缓冲区的数据，剩余部分通过 outBuffer 分多次 flush
+	data := make([]byte, 32<<20)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	srv.OnOpen(func(c Conn) {
+		_, _ = c.Write(data[:1024])
+		_, _ = c.Write(data[1024:])
+	})
+
+	testutil.StartServer(t, srv, 3*time.Second)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	got := make([]byte, len(data))
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("data mismatch")
+	}
+}
+
+func TestConnWritev(t *testing.T) {
+	addr := "127.0.0.1:9891"
+	srv, err := NewServer("tcp", addr, WithLoopNum(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 大量小的缓冲区（超过 IOV_MAX）加上超过内核发送缓冲区的消息体，覆盖 writev 写半包
+	var bs [][]byte
+	var want []byte
+	for i := 0; i < 2000; i++ {
+		b := []byte{byte(i), byte(i >> 8)}
+		bs = append(bs, b)
+		want = append(want, b...)
+	}
+	body := make([]byte, 8<<20)
+	for i := range body {
+		body[i] = byte(i % 251)
+	}
+	bs = append(bs, body)
+	want = append(want, body...)
+
+	srv.OnOpen(func(c Conn) {
+		_, _ = c.Writev(bs[:1])
+		_, _ = c.Writev(bs[1:])
+		_, _ = c.Writev([][]byte{[]byte("head"), []byte("tail")})
+	})
+	want = append(want, "headtail"...)
+
+	testutil.StartServer(t, srv, 3*time.Second)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	got := make([]byte, len(want))
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("data mismatch")
+	}
+}
+
+func TestConnIdleTimeout(t *testing.T) {
+	addr := "127.0.0.1:9892"
+	srv, err := NewServer("tcp", addr, WithLoopNum(1), WithIdleTimeout(300*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := make(chan time.Time, 1)
+	srv.OnTimeout(func(c Conn) { timeout <- time.Now() })
+	srv.OnRead(func(c Conn) {
+		buf := make([]byte, 64)
+		n, _ := c.Read(buf)
+		_, _ = c.Write(buf[:n])
+	})
+
+	testutil.StartServer(t, srv, 3*time.Second)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 持续有数据读写，不应该超时
+	start := time.Now()
+	buf := make([]byte, 64)
+	for i := 0; i < 5; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case at := <-timeout:
+		if at.Sub(start) < 500*time.Millisecond {
+			t.Fatalf("idle timeout fired while active, after %v", at.Sub(start))
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("idle timeout not fired")
+	}
+
+	// 超时后服务端关闭连接
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Read(buf); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestConnReadDeadline(t *testing.T) {
+	addr := "127.0.0.1:9893"
+	srv, err := NewServer("tcp", addr, WithLoopNum(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := make(chan struct{}, 1)
+	srv.OnTimeout(func(c Conn) { timeout <- struct{}{} })
+	srv.OnOpen(func(c Conn) {
+		if err := c.SetReadDeadline(time.Now().Add(200 * time.Millisecond)); err != nil {
+			t.Error(err)
+		}
+	})
+	srv.OnRead(func(c Conn) {
+		buf := make([]byte, 64)
+		n, _ := c.Read(buf)
+		// 收到数据后 deadline 失效，重新设置下一次的读超时
+		_ = c.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, _ = c.Write(buf[:n])
+	})
+
+	testutil.StartServer(t, srv, 3*time.Second)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 64)
+	for i := 0; i < 3; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case <-timeout:
+	case <-time.After(3 * time.Second):
+		t.Fatal("read deadline not fired")
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Read(buf); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
@@ -11,6 +11,44 @@ var (
 	ErrUnsupportedLength = errors.New("unsupported lengthFieldLength. (expected: 1, 2, 3, 4, or 8)")
 	// ErrTooLessLength occurs when adjusted frame length is less than zero.
 	ErrTooLessLength = errors.New("adjusted frame length is less than zero")
+	// ErrIncomplete occurs when the buffer does not contain a complete frame yet, more bytes are needed.
+	ErrIncomplete = errors.New("incomplete frame")
+	// ErrTooLongFrame occurs when the frame length exceeds the max frame length.
+	ErrTooLongFrame = errors.New("frame length exceeds max frame length")
+	// ErrCorruptedFrame occurs when the frame can not be decoded, e.g. negative adjusted length.
+	ErrCorruptedFrame = errors.New("corrupted frame")
+	// ErrMalformedVarint occurs when the varint length prefix is longer than 10 bytes or overflows 64 bits.
+	ErrMalformedVarint = errors.New("malformed varint length prefix")
+	// ErrFrameLengthMismatch occurs when encoding data whose length is not the fixed frame length.
+	ErrFrameLengthMismatch = errors.New("data length does not match the fixed frame length")
+	// ErrInvalidFrameLength occurs when creating a fixed length codec with a non-positive frame length.
+	ErrInvalidFrameLength = errors.New("frame length must be a positive integer")
+	// ErrEmptyDelimiter occurs when creating a delimiter based codec without any non-empty delimiter.
+	ErrEmptyDelimiter = errors.New("delimiter must not be empty")
+
+	// ================================================= http errors ==================================================.
+
+	// ErrMalformedRequest occurs when the request line, headers or chunked body can not be parsed.
+	ErrMalformedRequest = errors.New("malformed http request")
+	// ErrHeaderTooLarge occurs when the request line and headers exceed the max header bytes.
+	ErrHeaderTooLarge = errors.New("http request header too large")
+	// ErrBodyTooLarge occurs when the request body exceeds the max body bytes.
+	ErrBodyTooLarge = errors.New("http request body too large")
+	// ErrUnsupportedTransferEncoding occurs when the request uses a transfer coding other than chunked.
+	ErrUnsupportedTransferEncoding = errors.New("unsupported transfer encoding")
+	// ErrUnsupportedVersion occurs when the request is not HTTP/1.x.
+	ErrUnsupportedVersion = errors.New("unsupported http version")
+	// ErrExpectationFailed occurs when the request has an Expect header other than 100-continue.
+	ErrExpectationFailed = errors.New("unsupported expectation")
+
+	// ================================================= websocket errors =============================================.
+
+	// ErrBadHandshake occurs when the http request is not a valid websocket upgrade request.
+	ErrBadHandshake = errors.New("websocket: bad handshake")
+	// ErrNotWebSocket occurs when writing websocket messages to a connection that has not been upgraded.
+	ErrNotWebSocket = errors.New("websocket: connection has not been upgraded")
+	// ErrCloseSent occurs when writing websocket messages after the close frame has been sent.
+	ErrCloseSent = errors.New("websocket: close sent")
 
 	// ================================================= connect errors ===============================================.
 
@@ -20,6 +58,30 @@ var (
 	// ErrUnsupportedOp occurs when calling some methods that has not been implemented yet.
 	ErrUnsupportedOp = errors.New("unsupported operation")
 
+	// ErrInvalidLoopIndex occurs when submitting a task to an eventloop that does not exist.
+	ErrInvalidLoopIndex = errors.New("invalid eventloop index")
+
+	// ErrServerShutdown occurs when server is shutting down, returned by tasks to stop the eventloop.
+	ErrServerShutdown = errors.New("server is going to be shutdown")
+
+	// ErrEventLoopClosed occurs when submitting a task to a closed eventloop.
+	ErrEventLoopClosed = errors.New("eventloop closed")
+
+	// ErrInvalidInterval occurs when scheduling a periodic timer with a non-positive interval.
+	ErrInvalidInterval = errors.New("invalid timer interval")
+
+	// ErrClientNotStarted occurs when dialing before the client is started.
+	ErrClientNotStarted = errors.New("client not started")
+
+	// ErrPoolExhausted occurs when the connection pool reaches its maximum number of connections.
+	ErrPoolExhausted = errors.New("connection pool exhausted")
+
+	// ErrPoolClosed occurs when getting a connection from a closed pool.
+	ErrPoolClosed = errors.New("connection pool closed")
+
+	// ErrBufferFull occurs when the inbound buffer exceeds its maximum capacity.
+	ErrBufferFull = errors.New("buffer is full")
+
 	// ErrConnClosed occurs when calling some methods that has not been implemented yet.
 	ErrConnClosed = errors.New("connection closed")
 )
@@ -5,7 +5,6 @@ import (
 	"github.com/imlgw/jinx/internal"
 	"golang.org/x/sys/unix"
 	"log"
-	"net"
 	"sync/atomic"
 )
 
@@ -18,11 +17,28 @@ type eventloop struct {
 
 	conncnt uint64
 
+	pending int64 // outBuffer 中等待写入内核的字节数
+
+	opts    *Options
+	handler *eventHandler
+	// ser 所属的 server，accept 时选择 subReactor 使用，client 的 loop 为 nil
 	ser *server
+
+	// draining 正在优雅关闭，等待所有连接的 outBuffer flush 完成
+	draining bool
+
+	// buffer loop 内共享的读缓冲区，tcp 读取后追加到连接的 inBuffer，udp 数据报直接使用
+	buffer []byte
+	// peekBuf Conn.Peek 数据跨越 inBuffer 数组末尾时拼接使用
+	peekBuf []byte
+	// iovs flush outBuffer 时 writev 使用的切片，复用避免每次分配
+	iovs [][]byte
+	// batch udp 批量读写使用，开启 recvmmsg 时才创建
+	batch *packetBatch
 }
 
 // NewLoop 创建一个事件循环，idx 为循环序号
-func newLoop(idx int, ser *server) (*eventloop, error) {
+func newLoop(idx int, opts *Options, h *eventHandler) (*eventloop, error) {
 	epoll, err := internal.CreateEpoll()
 	if err != nil {
 		return nil, err
@@ -33,10 +49,24 @@ func newLoop(idx int, ser *server) (*eventloop, error) {
 		idx:     idx,
 		conncnt: 0,
 		reactor: make(map[int]reactor),
-		ser:     ser,
+		opts:    opts,
+		handler: h,
+		buffer:  make([]byte, 0xffff),
 	}, nil
 }
 
+func (loop *eventloop) Index() int        { return loop.idx }
+func (loop *eventloop) ConnCount() int    { return int(atomic.LoadUint64(&loop.conncnt)) }
+func (loop *eventloop) PendingBytes() int { return int(atomic.LoadInt64(&loop.pending)) }
+func (loop *eventloop) QueuedTasks() int  { return loop.epoll.QueuedTasks() }
+
+// addPending 更新 outBuffer 中等待写入的字节数
+func (loop *eventloop) addPending(delta int) {
+	if delta != 0 {
+		atomic.AddInt64(&loop.pending, int64(delta))
+	}
+}
+
 // Loop 开始事件循环
 func (loop *eventloop) poll() error {
 	if err := loop.epoll.Polling(
@@ -52,6 +82,10 @@ func (loop *eventloop) poll() error {
 					log.Printf("remove unused fd err, %v \n", err)
 				}
 			}
+			// 优雅关闭过程中所有连接都已经关闭，退出 loop
+			if loop.draining && len(loop.reactor) == 0 {
+				return errors.ErrServerShutdown
+			}
 			return nil
 		}); err != nil {
 		return err
@@ -61,8 +95,8 @@ func (loop *eventloop) poll() error {
 
 // read eventloop 可读事件处理，将内核中的数据写入 inBuffer
 func (loop *eventloop) handleReadEvent(c *connection) error {
-	// TODO: 动态调整 inBuffer 大小 （RingBuffer?）
-	n, err := unix.Read(c.fd, c.inBuffer)
+	// 先读到 loop 共享的 buffer 中，再追加到连接的 inBuffer，未被消费的数据会保留到下次可读事件
+	n, err := unix.Read(c.fd, loop.buffer)
 	if err != nil || n == 0 {
 		if err == unix.EAGAIN {
 			// https://stackoverflow.com/questions/14370489/what-can-cause-a-resource-temporarily-unavailable-on-sock-send-command
@@ -71,36 +105,68 @@ func (loop *eventloop) handleReadEvent(c *connection) error {
 		log.Printf("handleReadEvent err, %v \n", err)
 		return c.Close()
 	}
-	c.inBuffer = c.inBuffer[:n]
-	if loop.ser.onRead != nil {
-		loop.ser.onRead(c)
+	// 收到数据，读超时失效
+	c.active()
+	if c.readTimer != nil {
+		loop.epoll.StopTimer(c.readTimer)
+		c.readTimer = nil
+	}
+	if _, err := c.inBuffer.Write(loop.buffer[:n]); err != nil {
+		// 对端发送的数据超过 inBuffer 上限且没有被消费
+		log.Printf("handleReadEvent err, %v \n", err)
+		return c.Close()
+	}
+	if c.pipeline.head != nil {
+		return loop.firePipeline(c)
+	}
+	if c.codec != nil && loop.handler.onMessage != nil {
+		return loop.decodeMessages(c)
+	}
+	if loop.handler.onRead != nil {
+		loop.handler.onRead(c)
 	}
 	return nil
 }
 
 // write eventloop 可写事件处理，将 outBuffer 中的数据写入内核（flush）
 func (loop *eventloop) handleWriteEvent(c *connection) error {
-	if loop.ser.onWrite != nil {
-		loop.ser.onWrite(c)
+	if loop.handler.onWrite != nil {
+		loop.handler.onWrite(c)
 	}
 
-	if len(c.outBuffer) != 0 {
-		// 当内核缓冲区满的时候可能无法完全写入，writen < len(c.out)
-		writen, err := unix.Write(c.fd, c.outBuffer)
-		if err != nil && err != unix.EAGAIN {
-			log.Panicf("handleWriteEvent error, %v\n", err)
+	for !c.outBuffer.IsEmpty() {
+		// 当内核缓冲区满的时候可能无法完全写入，writen < 待写长度，剩余数据等待下次可写事件触发再 flush 到内核
+		loop.iovs = c.outBuffer.PeekBuffers(loop.iovs[:0], internal.IOVMax)
+		var size int
+		for _, b := range loop.iovs {
+			size += len(b)
+		}
+		writen, err := internal.Writev(c.fd, loop.iovs)
+		if err != nil {
+			if err == unix.EAGAIN {
+				break
+			}
+			log.Printf("handleWriteEvent error, %v\n", err)
 			return c.Close()
 		}
-		if writen == len(c.outBuffer) {
-			c.outBuffer = nil
-		} else {
-			// 剩余 c.out[writen:]，等待下次可写事件触发再 flush 到内核
-			c.outBuffer = c.outBuffer[writen:]
+		c.outBuffer.Discard(writen)
+		loop.addPending(-writen)
+		c.active()
+		if writen < size {
+			break
 		}
 	}
 
 	// c.out 中的数据已经全部写入内核，暂时不再需要监听写事件，当用户通过 conn 写入的时候再开启 write 事件
-	if len(c.outBuffer) == 0 {
+	if c.outBuffer.IsEmpty() {
+		if c.writeTimer != nil {
+			loop.epoll.StopTimer(c.writeTimer)
+			c.writeTimer = nil
+		}
+		// 优雅关闭或者 CloseAfterFlush 之后数据 flush 完成即可关闭连接
+		if loop.draining || c.closing {
+			return c.Close()
+		}
 		if err := c.loop.epoll.ModRead(c.fd); err != nil {
 			return err
 		}
@@ -125,46 +191,97 @@ func (loop *eventloop) handleAccept(fd int) error {
 		return err
 	}
 
-	addr := sockaddrToTCPOrUnixAddr(sa)
-	nextLoop := loop.ser.loopGroup.next(addr)
+	addr := internal.SockaddrToTCPOrUnixAddr(sa)
+	// ReusePort 模式下每个 loop 各自 accept，连接直接留在当前 loop
+	nextLoop := loop
+	if loop.ser.ln != nil && loop == loop.ser.ln.loop {
+		nextLoop = loop.ser.loopGroup.next(addr)
+	}
+
+	conn := newConnection(connfd, sa, addr, nextLoop)
+	// dual-stack 监听时本端地址可能是 IPv4 也可能是 IPv6，以内核返回的为准
+	if lsa, err := unix.Getsockname(connfd); err == nil {
+		conn.localAddr = internal.SockaddrToTCPOrUnixAddr(lsa)
+	}
+	atomic.AddUint64(&nextLoop.conncnt, 1)
+	if nextLoop == loop {
+		return loop.register(conn)
+	}
+	// 交给 subReactor 所在的 goroutine 注册，避免并发修改 reactor map
+	if err := nextLoop.epoll.Trigger(nextLoop.register, conn); err != nil {
+		log.Printf("trigger register conn error, %v \n", err)
+		atomic.AddUint64(&nextLoop.conncnt, ^uint64(0))
+		_ = unix.Close(connfd)
+		return err
+	}
+	return nil
+}
 
+// register 将 accept 的连接注册到当前 loop，在 loop 所在的 goroutine 中执行
+func (loop *eventloop) register(arg interface{}) error {
+	conn := arg.(*connection)
 	// 将 connfd 的读事件注册到 epoll 的 event_list
-	if err := nextLoop.epoll.RegRead(connfd); err != nil {
+	if err := loop.epoll.RegRead(conn.fd); err != nil {
 		log.Printf("reg connfd event rw error, %v \n", err)
-		_ = unix.Close(connfd)
+		atomic.AddUint64(&loop.conncnt, ^uint64(0))
+		_ = unix.Close(conn.fd)
 		return err
 	}
 
-	conn := newConnection(connfd, sa, addr, nextLoop)
 	// 将 conn 绑定到该 loop 对应 fd 的回调上
-	nextLoop.reactor[connfd] = conn
-	atomic.AddUint64(&nextLoop.conncnt, 1)
-	if loop.ser.onOpen != nil {
-		loop.ser.onOpen(conn)
-	}
+	loop.reactor[conn.fd] = conn
+	loop.opened(conn)
 	return nil
 }
 
-func sockaddrToTCPOrUnixAddr(sa unix.Sockaddr) net.Addr {
-	switch sa := (sa).(type) {
-	case *unix.SockaddrInet4:
-		return &net.TCPAddr{IP: sa.Addr[:], Port: sa.Port}
-		// TODO: IPV6
-	case *unix.SockaddrUnix:
-		return &net.UnixAddr{Name: sa.Name, Net: "unix"}
-	default:
-		log.Printf("(unknown - %T)", sa)
-		return nil
+// opened 连接建立（accept 或者 connect 完成），开启空闲超时并回调 OnOpen
+func (loop *eventloop) opened(conn *connection) {
+	if idle := loop.opts.IdleTimeout; idle > 0 {
+		conn.startIdleTimer(idle)
+	}
+	if loop.handler.onOpen != nil {
+		loop.handler.onOpen(conn)
 	}
 }
 
-// Close 停止事件循环
-func (loop *eventloop) Close() error {
-	if err := loop.epoll.Close(); err != nil {
-		return err
+// drain 开始优雅关闭，关闭没有待写数据的连接，其余连接只监听写事件等待 flush 完成，在 loop 所在的 goroutine 中执行
+func (loop *eventloop) drain(_ interface{}) error {
+	loop.draining = true
+	for fd, r := range loop.reactor {
+		c, ok := r.(*connection)
+		if !ok || c.outBuffer.IsEmpty() {
+			if err := r.Close(); err != nil {
+				log.Printf("close conn error, %v \n", err)
+			}
+			continue
+		}
+		if err := loop.epoll.ModWrite(fd); err != nil {
+			_ = c.Close()
+		}
+	}
+	if len(loop.reactor) == 0 {
+		return errors.ErrServerShutdown
 	}
-	loop.ser = nil
-	loop.reactor = nil
-	loop.epoll = nil
 	return nil
 }
+
+// forceClose 优雅关闭超时，关闭所有剩余连接并退出 loop
+func (loop *eventloop) forceClose(_ interface{}) error {
+	loop.closeAllConn()
+	return errors.ErrServerShutdown
+}
+
+func (loop *eventloop) closeAllConn() {
+	for _, r := range loop.reactor {
+		if err := r.Close(); err != nil {
+			log.Printf("close conn error, %v \n", err)
+		}
+	}
+}
+
+// Close 停止事件循环，先执行已经投递的任务（其中可能注册了新的连接），再关闭剩余的连接
+func (loop *eventloop) Close() error {
+	loop.epoll.Stop()
+	loop.closeAllConn()
+	return loop.epoll.Close()
+}
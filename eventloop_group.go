@@ -4,6 +4,7 @@ import (
 	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 )
 
 // type EventLoopGroup interface {
@@ -13,14 +14,19 @@ import (
 // }
 
 type eventLoopGroup struct {
-	wg          sync.WaitGroup
 	loops       []*eventloop
 	loadBalance interface {
 		next(loops []*eventloop, addr net.Addr) *eventloop
 	}
+	// mu 保护 loadBalance，mainReactor 以及调用 AsyncDial 的任意 goroutine 都会选择 loop，负载均衡本身不需要考虑并发
+	mu       sync.Mutex
+	timerSeq uint32 // AfterFunc/Every 轮流选择 loop
 }
 
-func newEventGroup(lb LoadBalance) *eventLoopGroup {
+func newEventGroup(lb LoadBalance, balancer Balancer) *eventLoopGroup {
+	if balancer != nil {
+		return &eventLoopGroup{loadBalance: &customBalancer{b: balancer}}
+	}
 	switch lb {
 	case LeastConnections:
 		return &eventLoopGroup{loadBalance: &leastConnections{}}
@@ -28,27 +34,31 @@ func newEventGroup(lb LoadBalance) *eventLoopGroup {
 		return &eventLoopGroup{loadBalance: &random{}}
 	case RoundRobin:
 		return &eventLoopGroup{loadBalance: &roundRobin{}}
+	case SourceAddrHash:
+		return &eventLoopGroup{loadBalance: &sourceAddrHash{}}
+	case ConsistentHash:
+		return &eventLoopGroup{loadBalance: &consistentHash{}}
 	default:
 		return &eventLoopGroup{loadBalance: &roundRobin{}}
 	}
 }
 
 func (g *eventLoopGroup) next(addr net.Addr) *eventloop {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 	return g.loadBalance.next(g.loops, addr)
 }
 
+// timerLoop 依次选择 loop 执行 AfterFunc/Every 定时器
+func (g *eventLoopGroup) timerLoop() *eventloop {
+	return g.loops[int(atomic.AddUint32(&g.timerSeq, 1)-1)%len(g.loops)]
+}
+
 func (g *eventLoopGroup) register(e *eventloop) {
-	g.wg.Add(1)
 	g.loops = append(g.loops, e)
 }
 
 func (g *eventLoopGroup) stopAll() error {
-	// 关闭所有 conn
-	if err := g.closeAllConn(); err != nil {
-		log.Printf("close CloseAllConn error  %v \n", err)
-		return err
-	}
-
 	for _, loop := range g.loops {
 		if err := loop.Close(); err != nil {
 			log.Printf("close eventloop error  %v \n", err)
@@ -57,14 +67,3 @@ func (g *eventLoopGroup) stopAll() error {
 	}
 	return nil
 }
-
-func (g *eventLoopGroup) closeAllConn() error {
-	for _, loop := range g.loops {
-		for _, conn := range loop.reactor {
-			if err := conn.Close(); err != nil {
-				return err
-			}
-		}
-	}
-	return nil
-}
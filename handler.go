@@ -1,10 +1,9 @@
 package jinx
 
-// handler event callback
-type handler interface {
+import "net"
 
-	// OnBoot server 启动
-	OnBoot(f func(s Server))
+// connHandler 连接相关的事件回调，server 和 client 共用
+type connHandler interface {
 
 	// OnOpen newConn 连接建立
 	OnOpen(f func(c Conn))
@@ -18,6 +17,47 @@ type handler interface {
 	// OnWrite 可写事件，在服务端发送数据到客户端之前
 	OnWrite(f func(c Conn))
 
+	// OnMessage 配置了 Codec 或者 Pipeline 时，每解码出一个完整的帧回调一次，一次可读事件可能回调多次。
+	// 设置了 OnMessage 之后不再回调 OnRead，msg 在回调结束后仍然有效
+	OnMessage(f func(c Conn, msg []byte))
+
+	// OnTimeout 连接读写超时或者空闲超时，回调之后关闭连接
+	OnTimeout(f func(c Conn))
+}
+
+// handler event callback
+type handler interface {
+	connHandler
+
+	// OnBoot server 启动
+	OnBoot(f func(s Server))
+
+	// OnDatagram udp 收到数据报，data 只在回调期间有效，通过 pc.WriteTo 回复
+	OnDatagram(f func(pc PacketConn, data []byte, from net.Addr))
+
 	// OnShutdown 服务关闭
 	OnShutdown(f func(s Server))
 }
+
+// eventHandler 保存 connHandler 注册的回调，eventloop 通过它回调连接事件
+type eventHandler struct {
+	onOpen     func(c Conn)
+	onClose    func(c Conn)
+	onRead     func(c Conn)
+	onWrite    func(c Conn)
+	onTimeout  func(c Conn)
+	onMessage  func(c Conn, msg []byte)
+	onDatagram func(pc PacketConn, data []byte, from net.Addr)
+}
+
+func (h *eventHandler) OnOpen(f func(c Conn))    { h.onOpen = f }
+func (h *eventHandler) OnClose(f func(c Conn))   { h.onClose = f }
+func (h *eventHandler) OnRead(f func(c Conn))    { h.onRead = f }
+func (h *eventHandler) OnWrite(f func(c Conn))   { h.onWrite = f }
+func (h *eventHandler) OnTimeout(f func(c Conn)) { h.onTimeout = f }
+func (h *eventHandler) OnMessage(f func(c Conn, msg []byte)) {
+	h.onMessage = f
+}
+func (h *eventHandler) OnDatagram(f func(pc PacketConn, data []byte, from net.Addr)) {
+	h.onDatagram = f
+}
@@ -0,0 +1,23 @@
+package jinx
+
+import (
+	"github.com/imlgw/jinx/internal/testutil"
+	"testing"
+	"time"
+)
+
+// startEchoServer 启动回显服务器，测试结束时自动停止
+func startEchoServer(t *testing.T, addr string) Server {
+	t.Helper()
+	srv, err := NewServer("tcp", addr, WithLoopNum(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.OnRead(func(c Conn) {
+		buf := make([]byte, 1024)
+		n, _ := c.Read(buf)
+		_, _ = c.Write(buf[:n])
+	})
+	testutil.StartServer(t, srv, 3*time.Second)
+	return srv
+}
@@ -0,0 +1,381 @@
+package http
+
+import (
+	"bytes"
+	"github.com/imlgw/jinx"
+	"github.com/imlgw/jinx/errors"
+	"io"
+	nethttp "net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Request 解析出的 HTTP/1.1 请求，Body 已经完整读取（包括 chunked 编码的请求体）
+type Request struct {
+	Method     string
+	RequestURI string
+	URL        *url.URL
+	Proto      string // "HTTP/1.1"
+	ProtoMajor int
+	ProtoMinor int
+	Header     nethttp.Header
+	Host       string
+
+	// ContentLength 请求体长度，chunked 编码时为解码之后的长度
+	ContentLength    int64
+	TransferEncoding []string
+	Body             []byte
+	// Trailer chunked 编码请求体之后的 trailer 头部
+	Trailer nethttp.Header
+
+	// Close 处理完这个请求之后关闭连接（Connection: close 或者 HTTP/1.0 没有 keep-alive）
+	Close      bool
+	RemoteAddr string
+	// Conn 请求所在的连接，只能在 eventloop 中使用
+	Conn jinx.Conn
+}
+
+// Std 转换为标准库的 *http.Request，用于适配 http.Handler
+func (r *Request) Std() *nethttp.Request {
+	return &nethttp.Request{
+		Method:           r.Method,
+		URL:              r.URL,
+		Proto:            r.Proto,
+		ProtoMajor:       r.ProtoMajor,
+		ProtoMinor:       r.ProtoMinor,
+		Header:           r.Header,
+		Body:             io.NopCloser(bytes.NewReader(r.Body)),
+		ContentLength:    r.ContentLength,
+		TransferEncoding: r.TransferEncoding,
+		Close:            r.Close,
+		Host:             r.Host,
+		Trailer:          r.Trailer,
+		RemoteAddr:       r.RemoteAddr,
+		RequestURI:       r.RequestURI,
+	}
+}
+
+// 解析状态
+const (
+	stateHeader = iota
+	stateBody
+	stateChunkSize
+	stateChunkData
+	stateTrailer
+)
+
+const (
+	// maxChunkLineBytes chunk size 行以及 trailer 每一行的最大长度
+	maxChunkLineBytes = 4096
+)
+
+var crlf = []byte("\r\n")
+
+// parser 增量解析连接上的请求，每个连接一个，数据不足时保留已经收到的数据等待下一次可读事件
+type parser struct {
+	maxHeaderBytes int
+	maxBodyBytes   int64
+
+	buf []byte // 尚未解析的数据为 buf[off:]
+	off int
+
+	state  int
+	req    *Request
+	body   []byte
+	remain int64 // stateBody 时剩余的请求体长度，stateChunkData 时当前 chunk 剩余的长度
+
+	// expectContinue 请求头要求 100-continue 并且还没有收到请求体，调用方应该先回复 100 Continue
+	expectContinue bool
+}
+
+func newParser(maxHeaderBytes int, maxBodyBytes int64) *parser {
+	return &parser{maxHeaderBytes: maxHeaderBytes, maxBodyBytes: maxBodyBytes}
+}
+
+// feed 追加收到的数据，已经解析的数据移出 buf，避免 buf 无限增长
+func (p *parser) feed(data []byte) {
+	if p.off > 0 {
+		n := copy(p.buf, p.buf[p.off:])
+		p.buf = p.buf[:n]
+		p.off = 0
+	}
+	p.buf = append(p.buf, data...)
+}
+
+// rest 返回并清空尚未解析的数据
+func (p *parser) rest() []byte {
+	rest := p.buf[p.off:]
+	p.buf, p.off = nil, 0
+	return rest
+}
+
+// next 解析下一个完整的请求，数据不足时返回 errors.ErrIncomplete，其他错误说明请求无法解析，应该回复错误并关闭连接
+func (p *parser) next() (*Request, error) {
+	for {
+		var err error
+		switch p.state {
+		case stateHeader:
+			err = p.parseHeader()
+		case stateBody:
+			err = p.parseBody()
+		case stateChunkSize:
+			err = p.parseChunkSize()
+		case stateChunkData:
+			err = p.parseChunkData()
+		case stateTrailer:
+			err = p.parseTrailer()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if p.state == stateHeader && p.req != nil {
+			req := p.req
+			req.Body = p.body
+			if req.TransferEncoding != nil {
+				req.ContentLength = int64(len(p.body))
+			}
+			p.req, p.body = nil, nil
+			return req, nil
+		}
+	}
+}
+
+// line 返回 buf 中下一行（不包括 CRLF），没有完整的一行时返回 nil
+func (p *parser) line(max int) ([]byte, error) {
+	idx := bytes.Index(p.buf[p.off:], crlf)
+	if idx < 0 {
+		if len(p.buf)-p.off > max {
+			return nil, errors.ErrMalformedRequest
+		}
+		return nil, errors.ErrIncomplete
+	}
+	line := p.buf[p.off : p.off+idx]
+	p.off += idx + 2
+	return line, nil
+}
+
+func (p *parser) parseHeader() error {
+	// RFC 7230 3.5 忽略请求行之前的空行
+	for bytes.HasPrefix(p.buf[p.off:], crlf) {
+		p.off += 2
+	}
+	idx := bytes.Index(p.buf[p.off:], []byte("\r\n\r\n"))
+	if idx < 0 {
+		if len(p.buf)-p.off > p.maxHeaderBytes {
+			return errors.ErrHeaderTooLarge
+		}
+		return errors.ErrIncomplete
+	}
+	if idx > p.maxHeaderBytes {
+		return errors.ErrHeaderTooLarge
+	}
+	head := p.buf[p.off : p.off+idx]
+	p.off += idx + 4
+
+	lines := strings.Split(string(head), "\r\n")
+	req, err := parseRequestLine(lines[0])
+	if err != nil {
+		return err
+	}
+	for _, line := range lines[1:] {
+		if err := addHeader(req.Header, line); err != nil {
+			return err
+		}
+	}
+	if err := p.prepare(req); err != nil {
+		return err
+	}
+	p.req = req
+	return nil
+}
+
+func parseRequestLine(line string) (*Request, error) {
+	parts := strings.Split(line, " ")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return nil, errors.ErrMalformedRequest
+	}
+	major, minor, ok := nethttp.ParseHTTPVersion(parts[2])
+	if !ok {
+		return nil, errors.ErrMalformedRequest
+	}
+	if major != 1 {
+		return nil, errors.ErrUnsupportedVersion
+	}
+	req := &Request{
+		Method:     parts[0],
+		RequestURI: parts[1],
+		Proto:      parts[2],
+		ProtoMajor: major,
+		ProtoMinor: minor,
+		Header:     make(nethttp.Header),
+	}
+	if req.RequestURI == "*" {
+		req.URL = &url.URL{Path: "*"}
+		return req, nil
+	}
+	u, err := url.ParseRequestURI(req.RequestURI)
+	if err != nil {
+		return nil, errors.ErrMalformedRequest
+	}
+	req.URL = u
+	return req, nil
+}
+
+// addHeader 解析一行 "Key: value"，不支持 obs-fold，冒号前不能有空白（RFC 7230 3.2.4，避免请求走私）
+func addHeader(h nethttp.Header, line string) error {
+	i := strings.IndexByte(line, ':')
+	if i <= 0 || strings.ContainsAny(line[:i], " \t") || line[0] == ' ' || line[0] == '\t' {
+		return errors.ErrMalformedRequest
+	}
+	h.Add(textproto.CanonicalMIMEHeaderKey(line[:i]), strings.TrimSpace(line[i+1:]))
+	return nil
+}
+
+// prepare 根据请求头确定 Host、keep-alive 以及请求体的格式
+func (p *parser) prepare(req *Request) error {
+	h := req.Header
+	req.Host = h.Get("Host")
+	if req.ProtoMinor >= 1 && len(h["Host"]) != 1 {
+		return errors.ErrMalformedRequest
+	}
+	if req.URL.Host != "" {
+		req.Host = req.URL.Host
+	}
+
+	if hasToken(h["Connection"], "close") {
+		req.Close = true
+	} else if req.ProtoMinor == 0 && !hasToken(h["Connection"], "keep-alive") {
+		req.Close = true
+	}
+
+	te := h["Transfer-Encoding"]
+	cl := h["Content-Length"]
+	if len(te) > 0 {
+		// 同时存在 Transfer-Encoding 和 Content-Length 可能是请求走私，直接拒绝
+		if len(cl) > 0 || req.ProtoMinor == 0 {
+			return errors.ErrMalformedRequest
+		}
+		if len(te) != 1 || !strings.EqualFold(strings.TrimSpace(te[0]), "chunked") {
+			return errors.ErrUnsupportedTransferEncoding
+		}
+		req.TransferEncoding = []string{"chunked"}
+		req.ContentLength = -1
+		h.Del("Transfer-Encoding")
+		p.state = stateChunkSize
+	} else if len(cl) > 0 {
+		for _, v := range cl[1:] {
+			if v != cl[0] {
+				return errors.ErrMalformedRequest
+			}
+		}
+		n, err := strconv.ParseInt(cl[0], 10, 64)
+		if err != nil || n < 0 {
+			return errors.ErrMalformedRequest
+		}
+		if n > p.maxBodyBytes {
+			return errors.ErrBodyTooLarge
+		}
+		req.ContentLength = n
+		if n > 0 {
+			p.remain = n
+			p.state = stateBody
+		}
+	}
+
+	if expect := h.Get("Expect"); expect != "" {
+		if !strings.EqualFold(expect, "100-continue") {
+			return errors.ErrExpectationFailed
+		}
+		// 客户端已经发送了请求体的时候不需要再回复 100 Continue
+		p.expectContinue = req.ProtoMinor >= 1 && p.state != stateHeader && p.off == len(p.buf)
+	}
+	return nil
+}
+
+func (p *parser) parseBody() error {
+	if int64(len(p.buf)-p.off) < p.remain {
+		return errors.ErrIncomplete
+	}
+	n := int(p.remain)
+	p.body = append(make([]byte, 0, n), p.buf[p.off:p.off+n]...)
+	p.off += n
+	p.remain = 0
+	p.state = stateHeader
+	return nil
+}
+
+func (p *parser) parseChunkSize() error {
+	line, err := p.line(maxChunkLineBytes)
+	if err != nil {
+		return err
+	}
+	// 忽略 chunk extension
+	if i := bytes.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(line)), 16, 64)
+	if err != nil || size < 0 {
+		return errors.ErrMalformedRequest
+	}
+	if size == 0 {
+		p.state = stateTrailer
+		return nil
+	}
+	if size > p.maxBodyBytes-int64(len(p.body)) {
+		return errors.ErrBodyTooLarge
+	}
+	p.remain = size
+	p.state = stateChunkData
+	return nil
+}
+
+func (p *parser) parseChunkData() error {
+	n := int(p.remain)
+	if len(p.buf)-p.off < n+2 {
+		return errors.ErrIncomplete
+	}
+	if !bytes.Equal(p.buf[p.off+n:p.off+n+2], crlf) {
+		return errors.ErrMalformedRequest
+	}
+	p.body = append(p.body, p.buf[p.off:p.off+n]...)
+	p.off += n + 2
+	p.remain = 0
+	p.state = stateChunkSize
+	return nil
+}
+
+func (p *parser) parseTrailer() error {
+	for {
+		line, err := p.line(maxChunkLineBytes)
+		if err != nil {
+			return err
+		}
+		if len(line) == 0 {
+			if p.body == nil {
+				p.body = []byte{}
+			}
+			p.state = stateHeader
+			return nil
+		}
+		if p.req.Trailer == nil {
+			p.req.Trailer = make(nethttp.Header)
+		}
+		if err := addHeader(p.req.Trailer, string(line)); err != nil {
+			return err
+		}
+	}
+}
+
+// hasToken 逗号分隔的头部值中是否包含 token，不区分大小写
+func hasToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
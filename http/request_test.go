@@ -0,0 +1,114 @@
+package http
+
+import (
+	"github.com/imlgw/jinx/errors"
+	"testing"
+)
+
+func TestParser_Incremental(t *testing.T) {
+	raw := "GET /a?x=1 HTTP/1.1\r\nHost: imlgw.top\r\nX-Test:  v1 \r\nX-Test: v2\r\n\r\n"
+	p := newParser(1<<20, 1<<20)
+	for i := 0; i < len(raw)-1; i++ {
+		p.feed([]byte{raw[i]})
+		if _, err := p.next(); err != errors.ErrIncomplete {
+			t.Fatalf("expected ErrIncomplete at %d, got %v", i, err)
+		}
+	}
+	p.feed([]byte{raw[len(raw)-1]})
+	req, err := p.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != "GET" || req.URL.Path != "/a" || req.URL.Query().Get("x") != "1" || req.Host != "imlgw.top" {
+		t.Fatalf("unexpected request %+v", req)
+	}
+	if v := req.Header["X-Test"]; len(v) != 2 || v[0] != "v1" || v[1] != "v2" {
+		t.Fatalf("unexpected header %v", v)
+	}
+	if req.Close {
+		t.Fatal("HTTP/1.1 should keep alive by default")
+	}
+}
+
+func TestParser_Pipelining(t *testing.T) {
+	p := newParser(1<<20, 1<<20)
+	p.feed([]byte("POST /1 HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\nhello" +
+		"GET /2 HTTP/1.0\r\n\r\n" +
+		"GET /3 HTTP/1.1\r\nHost: a\r\n"))
+	req, err := p.next()
+	if err != nil || req.URL.Path != "/1" || string(req.Body) != "hello" || req.ContentLength != 5 {
+		t.Fatalf("unexpected request %+v, %v", req, err)
+	}
+	req, err = p.next()
+	if err != nil || req.URL.Path != "/2" || !req.Close {
+		t.Fatalf("unexpected request %+v, %v", req, err)
+	}
+	if _, err := p.next(); err != errors.ErrIncomplete {
+		t.Fatalf("expected ErrIncomplete, got %v", err)
+	}
+	p.feed([]byte("Connection: close\r\n\r\n"))
+	req, err = p.next()
+	if err != nil || req.URL.Path != "/3" || !req.Close {
+		t.Fatalf("unexpected request %+v, %v", req, err)
+	}
+}
+
+func TestParser_Chunked(t *testing.T) {
+	p := newParser(1<<20, 1<<20)
+	p.feed([]byte("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5;ext=1\r\nhello\r\n1\r\n \r\n"))
+	if _, err := p.next(); err != errors.ErrIncomplete {
+		t.Fatalf("expected ErrIncomplete, got %v", err)
+	}
+	p.feed([]byte("5\r\nworld\r\n0\r\nX-Trailer: t\r\n\r\n"))
+	req, err := p.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(req.Body) != "hello world" || req.ContentLength != 11 || req.Trailer.Get("X-Trailer") != "t" {
+		t.Fatalf("unexpected request %+v", req)
+	}
+}
+
+func TestParser_ExpectContinue(t *testing.T) {
+	p := newParser(1<<20, 1<<20)
+	p.feed([]byte("PUT / HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\nExpect: 100-continue\r\n\r\n"))
+	if _, err := p.next(); err != errors.ErrIncomplete || !p.expectContinue {
+		t.Fatalf("expected 100-continue, got %v", err)
+	}
+
+	// 请求体已经一起发送时不需要 100 Continue
+	p = newParser(1<<20, 1<<20)
+	p.feed([]byte("PUT / HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\nExpect: 100-continue\r\n\r\nab"))
+	if _, err := p.next(); err != errors.ErrIncomplete || p.expectContinue {
+		t.Fatalf("unexpected 100-continue, %v", err)
+	}
+}
+
+func TestParser_Errors(t *testing.T) {
+	cases := []struct {
+		raw string
+		err error
+	}{
+		{"GET / HTTP/1.1\r\n\r\n", errors.ErrMalformedRequest},
+		{"GET /\r\n\r\n", errors.ErrMalformedRequest},
+		{"GET / HTTP/2.0\r\nHost: a\r\n\r\n", errors.ErrUnsupportedVersion},
+		{"GET / HTTP/1.1\r\nHost : a\r\n\r\n", errors.ErrMalformedRequest},
+		{"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 1\r\nTransfer-Encoding: chunked\r\n\r\n", errors.ErrMalformedRequest},
+		{"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\n", errors.ErrMalformedRequest},
+		{"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: gzip\r\n\r\n", errors.ErrUnsupportedTransferEncoding},
+		{"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 1025\r\n\r\n", errors.ErrBodyTooLarge},
+		{"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n401\r\n", errors.ErrBodyTooLarge},
+		{"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n", errors.ErrMalformedRequest},
+		{"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n1\r\nab\r\n", errors.ErrMalformedRequest},
+		{"PUT / HTTP/1.1\r\nHost: a\r\nExpect: something\r\n\r\n", errors.ErrExpectationFailed},
+		{"GET /" + string(make([]byte, 2048)), errors.ErrHeaderTooLarge},
+	}
+	for _, c := range cases {
+		p := newParser(1024, 1024)
+		p.feed([]byte(c.raw))
+		if _, err := p.next(); err != c.err {
+			t.Errorf("%q: expected %v, got %v", c.raw, c.err, err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package http
+
+import (
+	"bytes"
+	nethttp "net/http"
+	"strconv"
+	"time"
+)
+
+// ResponseWriter 与标准库的 http.ResponseWriter 相同，响应先写入内存，处理函数返回之后一次写入连接的 outBuffer
+type ResponseWriter = nethttp.ResponseWriter
+
+// response 实现 ResponseWriter，每个请求一个
+type response struct {
+	req         *Request
+	header      nethttp.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func newResponse(req *Request) *response {
+	return &response{req: req, header: make(nethttp.Header)}
+}
+
+func (w *response) Header() nethttp.Header { return w.header }
+
+// WriteHeader 设置状态码，只有第一次调用生效
+func (w *response) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = code
+}
+
+func (w *response) Write(b []byte) (int, error) {
+	w.WriteHeader(nethttp.StatusOK)
+	if !bodyAllowed(w.status) {
+		return 0, nethttp.ErrBodyNotAllowed
+	}
+	return w.body.Write(b)
+}
+
+// closeAfter 响应之后是否关闭连接
+func (w *response) closeAfter() bool {
+	return w.req.Close || hasToken(w.header["Connection"], "close")
+}
+
+// bytes 生成状态行、头部以及响应体
+func (w *response) bytes() []byte {
+	w.WriteHeader(nethttp.StatusOK)
+	h := w.header
+	if h.Get("Date") == "" {
+		h.Set("Date", time.Now().UTC().Format(nethttp.TimeFormat))
+	}
+	// 响应体已经完整的保存在内存中，总是使用 Content-Length
+	h.Del("Transfer-Encoding")
+	if bodyAllowed(w.status) {
+		if h.Get("Content-Length") == "" {
+			h.Set("Content-Length", strconv.Itoa(w.body.Len()))
+		}
+		if h.Get("Content-Type") == "" && w.body.Len() > 0 {
+			h.Set("Content-Type", nethttp.DetectContentType(w.body.Bytes()))
+		}
+	}
+	if w.closeAfter() {
+		h.Set("Connection", "close")
+	} else if w.req.ProtoMinor == 0 {
+		h.Set("Connection", "keep-alive")
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(128 + w.body.Len())
+	writeStatusLine(&buf, w.status)
+	_ = h.Write(&buf)
+	buf.WriteString("\r\n")
+	if w.req.Method != nethttp.MethodHead {
+		buf.Write(w.body.Bytes())
+	}
+	return buf.Bytes()
+}
+
+func writeStatusLine(buf *bytes.Buffer, code int) {
+	buf.WriteString("HTTP/1.1 ")
+	buf.WriteString(strconv.Itoa(code))
+	buf.WriteByte(' ')
+	buf.WriteString(nethttp.StatusText(code))
+	buf.WriteString("\r\n")
+}
+
+// bodyAllowed 1xx、204 以及 304 响应不能有响应体
+func bodyAllowed(status int) bool {
+	return (status < 100 || status > 199) && status != nethttp.StatusNoContent && status != nethttp.StatusNotModified
+}
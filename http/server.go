@@ -0,0 +1,192 @@
+package http
+
+import (
+	"bytes"
+	"github.com/imlgw/jinx"
+	"github.com/imlgw/jinx/errors"
+	"log"
+	nethttp "net/http"
+	"runtime/debug"
+	"strconv"
+)
+
+// Handler 处理 HTTP 请求，在连接所属的 eventloop 中同步执行，不能阻塞
+type Handler interface {
+	ServeHTTP(w ResponseWriter, r *Request)
+}
+
+// HandlerFunc 将函数适配为 Handler
+type HandlerFunc func(w ResponseWriter, r *Request)
+
+func (f HandlerFunc) ServeHTTP(w ResponseWriter, r *Request) { f(w, r) }
+
+// Adapt 将标准库的 http.Handler 适配为 Handler，请求体已经完整读取，同样在 eventloop 中同步执行。
+// 响应在处理函数返回之后才写入连接，不支持 http.Flusher 以及 http.Hijacker
+func Adapt(h nethttp.Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		h.ServeHTTP(w, r.Std())
+	})
+}
+
+// Codec pipeline 中解析 HTTP/1.1 请求的阶段，参考 netty 的 HttpServerCodec。
+// 入站数据增量解析，支持 pipelining、chunked 请求体、keep-alive 以及 Expect: 100-continue，
+// 每解析出一个完整的请求回调一次 Handler，响应按照请求的顺序写入连接，不会为连接创建 goroutine
+type Codec struct {
+	handler        Handler
+	maxHeaderBytes int
+	maxBodyBytes   int64
+}
+
+// CodecOption Codec 的可选配置
+type CodecOption func(c *Codec)
+
+// WithMaxHeaderBytes 请求行以及头部的最大长度，超过时回复 431 并关闭连接，默认 1MB
+func WithMaxHeaderBytes(n int) CodecOption {
+	return func(c *Codec) {
+		c.maxHeaderBytes = n
+	}
+}
+
+// WithMaxBodyBytes 请求体的最大长度，超过时回复 413 并关闭连接，默认 10MB
+func WithMaxBodyBytes(n int64) CodecOption {
+	return func(c *Codec) {
+		c.maxBodyBytes = n
+	}
+}
+
+func NewCodec(h Handler, opts ...CodecOption) *Codec {
+	c := &Codec{
+		handler:        h,
+		maxHeaderBytes: nethttp.DefaultMaxHeaderBytes,
+		maxBodyBytes:   10 << 20,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// NewServer 创建 HTTP/1.1 服务器，需要与其他 pipeline 阶段（例如 TLS）组合时使用 jinx.WithPipeline 以及 NewCodec
+func NewServer(network, addr string, h Handler, opts ...jinx.Option) (jinx.Server, error) {
+	opts = append(opts, jinx.WithPipeline(NewCodec(h)))
+	return jinx.NewServer(network, addr, opts...)
+}
+
+// connState Codec 在每个连接上的状态
+type connState struct {
+	p       *parser
+	closing bool // 已经回复了 Connection: close，不再解析之后的请求
+}
+
+var continueResponse = []byte("HTTP/1.1 100 Continue\r\n\r\n")
+
+func (c *Codec) HandleRead(ctx *jinx.HandlerContext, msg []byte) error {
+	st, ok := ctx.State.(*connState)
+	if !ok {
+		st = &connState{p: newParser(c.maxHeaderBytes, c.maxBodyBytes)}
+		ctx.State = st
+	}
+	if st.closing {
+		return nil
+	}
+	st.p.feed(msg)
+	for {
+		req, err := st.p.next()
+		if err == errors.ErrIncomplete {
+			if st.p.expectContinue {
+				st.p.expectContinue = false
+				return ctx.FireWrite(continueResponse)
+			}
+			return nil
+		}
+		if err != nil {
+			st.closing = true
+			return c.writeError(ctx, err)
+		}
+		st.p.expectContinue = false
+
+		conn := ctx.Conn()
+		req.Conn = conn
+		req.RemoteAddr = conn.RemoteAddr().String()
+		w := newResponse(req)
+		if !c.serve(w, req) {
+			st.closing = true
+			if !conn.IsOpen() {
+				return nil
+			}
+			if ctx.Removed() {
+				return conn.Close()
+			}
+			return c.writeStatus(ctx, nethttp.StatusInternalServerError, nethttp.StatusText(nethttp.StatusInternalServerError))
+		}
+		if !conn.IsOpen() {
+			return nil
+		}
+		// 处理函数中升级了协议（例如 WebSocket）替换了当前阶段，响应已经由新的阶段写入，剩余的数据交给新的阶段
+		if ctx.Removed() {
+			if rest := st.p.rest(); len(rest) > 0 {
+				return ctx.FireRead(rest)
+			}
+			return nil
+		}
+		if err := ctx.FireWrite(w.bytes()); err != nil {
+			return err
+		}
+		if w.closeAfter() {
+			st.closing = true
+			return conn.CloseAfterFlush()
+		}
+	}
+}
+
+// serve 调用 Handler，处理函数 panic 时返回 false，与 net/http 一样记录日志（http.ErrAbortHandler 除外），
+// 不会导致 eventloop 退出
+func (c *Codec) serve(w ResponseWriter, req *Request) (ok bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			if err != nethttp.ErrAbortHandler {
+				log.Printf("http: panic serving %s: %v\n%s", req.RemoteAddr, err, debug.Stack())
+			}
+			ok = false
+		}
+	}()
+	c.handler.ServeHTTP(w, req)
+	return true
+}
+
+// HandleWrite Conn.Send 的数据直接写入连接
+func (c *Codec) HandleWrite(ctx *jinx.HandlerContext, msg []byte) error {
+	return ctx.FireWrite(msg)
+}
+
+// writeError 请求无法解析时回复对应的状态码并在 flush 之后关闭连接
+func (c *Codec) writeError(ctx *jinx.HandlerContext, err error) error {
+	code := nethttp.StatusBadRequest
+	switch err {
+	case errors.ErrHeaderTooLarge:
+		code = nethttp.StatusRequestHeaderFieldsTooLarge
+	case errors.ErrBodyTooLarge:
+		code = nethttp.StatusRequestEntityTooLarge
+	case errors.ErrUnsupportedTransferEncoding:
+		code = nethttp.StatusNotImplemented
+	case errors.ErrUnsupportedVersion:
+		code = nethttp.StatusHTTPVersionNotSupported
+	case errors.ErrExpectationFailed:
+		code = nethttp.StatusExpectationFailed
+	}
+	return c.writeStatus(ctx, code, err.Error())
+}
+
+// writeStatus 回复状态码以及纯文本的 body，在 flush 之后关闭连接
+func (c *Codec) writeStatus(ctx *jinx.HandlerContext, code int, body string) error {
+	var buf bytes.Buffer
+	writeStatusLine(&buf, code)
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\nConnection: close\r\nContent-Length: ")
+	buf.WriteString(strconv.Itoa(len(body)))
+	buf.WriteString("\r\n\r\n")
+	buf.WriteString(body)
+	if err := ctx.FireWrite(buf.Bytes()); err != nil {
+		return err
+	}
+	return ctx.Conn().CloseAfterFlush()
+}
@@ -0,0 +1,197 @@
+package http
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/imlgw/jinx"
+	"github.com/imlgw/jinx/internal/testutil"
+	"io"
+	"net"
+	nethttp "net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	addr := "127.0.0.1:9907"
+	mux := nethttp.NewServeMux()
+	mux.HandleFunc("/std", func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		_, _ = fmt.Fprintf(w, "std:%s", body)
+	})
+	std := Adapt(mux)
+	srv, err := NewServer("tcp", addr, HandlerFunc(func(w ResponseWriter, r *Request) {
+		if strings.HasPrefix(r.URL.Path, "/std") {
+			std.ServeHTTP(w, r)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, r.Body)
+	}), jinx.WithLoopNum(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.StartServer(t, srv, 3*time.Second)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	r := bufio.NewReader(conn)
+
+	expect := func(method, body string) *nethttp.Response {
+		t.Helper()
+		resp, err := nethttp.ReadResponse(r, &nethttp.Request{Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != nethttp.StatusOK || string(got) != body {
+			t.Fatalf("unexpected response %d %q, want %q", resp.StatusCode, got, body)
+		}
+		return resp
+	}
+
+	// pipelining：一次写入多个请求，按顺序收到响应
+	_, _ = conn.Write([]byte("GET /a HTTP/1.1\r\nHost: a\r\n\r\n" +
+		"POST /b HTTP/1.1\r\nHost: a\r\nContent-Length: 2\r\n\r\nhi" +
+		"POST /c HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n"))
+	expect("GET", "GET /a ")
+	expect("POST", "POST /b hi")
+	_, _ = conn.Write([]byte("2\r\nde\r\n0\r\n\r\nHEAD /d HTTP/1.1\r\nHost: a\r\n\r\n"))
+	expect("POST", "POST /c abcde")
+	if resp := expect("HEAD", ""); resp.ContentLength != int64(len("HEAD /d ")) {
+		t.Fatalf("unexpected HEAD content length %d", resp.ContentLength)
+	}
+
+	// Expect: 100-continue
+	_, _ = conn.Write([]byte("PUT /std HTTP/1.1\r\nHost: a\r\nContent-Length: 4\r\nExpect: 100-continue\r\n\r\n"))
+	line, err := r.ReadString('\n')
+	if err != nil || line != "HTTP/1.1 100 Continue\r\n" {
+		t.Fatalf("unexpected continue response %q, %v", line, err)
+	}
+	if line, _ = r.ReadString('\n'); line != "\r\n" {
+		t.Fatalf("unexpected continue response %q", line)
+	}
+	_, _ = conn.Write([]byte("body"))
+	if resp := expect("PUT", "std:body"); resp.Header.Get("X-Method") != "PUT" {
+		t.Fatalf("unexpected header %v", resp.Header)
+	}
+
+	// Connection: close 响应之后关闭连接
+	_, _ = conn.Write([]byte("GET /e HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\nGET /f HTTP/1.1\r\nHost: a\r\n\r\n"))
+	if resp := expect("GET", "GET /e "); !resp.Close {
+		t.Fatal("expected Connection: close")
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestServerBadRequest(t *testing.T) {
+	addr := "127.0.0.1:9908"
+	srv, err := NewServer("tcp", addr, HandlerFunc(func(w ResponseWriter, r *Request) {
+		t.Errorf("unexpected request %v", r.URL)
+	}), jinx.WithLoopNum(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.StartServer(t, srv, 3*time.Second)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	_, _ = conn.Write([]byte("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: gzip\r\n\r\n"))
+	r := bufio.NewReader(conn)
+	resp, err := nethttp.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != nethttp.StatusNotImplemented || !resp.Close {
+		t.Fatalf("unexpected response %d", resp.StatusCode)
+	}
+	_, _ = io.ReadAll(resp.Body)
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestServerHandlerPanic(t *testing.T) {
+	addr := "127.0.0.1:9917"
+	srv, err := NewServer("tcp", addr, HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/panic" {
+			panic("boom")
+		}
+		_, _ = w.Write([]byte("ok"))
+	}), jinx.WithLoopNum(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.StartServer(t, srv, 3*time.Second)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	// panic 之后 pipelining 的请求不再处理
+	_, _ = conn.Write([]byte("GET /panic HTTP/1.1\r\nHost: a\r\n\r\nGET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	r := bufio.NewReader(conn)
+	resp, err := nethttp.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != nethttp.StatusInternalServerError || !resp.Close {
+		t.Fatalf("unexpected response %d", resp.StatusCode)
+	}
+	_, _ = io.ReadAll(resp.Body)
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+
+	// eventloop 没有受到影响，新的连接正常处理
+	resp, err = nethttp.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != nethttp.StatusOK || string(body) != "ok" {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestServerStdClient(t *testing.T) {
+	addr := "127.0.0.1:9909"
+	srv, err := NewServer("tcp", addr, HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(nethttp.StatusCreated)
+		_, _ = w.Write(r.Body)
+	}), jinx.WithLoopNum(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.StartServer(t, srv, 3*time.Second)
+
+	cli := &nethttp.Client{Timeout: 3 * time.Second}
+	defer cli.CloseIdleConnections()
+	for i := 0; i < 5; i++ {
+		body := strings.Repeat("x", i*10000)
+		resp, err := cli.Post("http://"+addr+"/", "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode != nethttp.StatusCreated || string(got) != body {
+			t.Fatalf("unexpected response %d, %d bytes", resp.StatusCode, len(got))
+		}
+	}
+}
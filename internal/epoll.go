@@ -1,10 +1,24 @@
 package internal
 
 import (
+	"github.com/imlgw/jinx/errors"
 	"golang.org/x/sys/unix"
 	"log"
+	"sync"
+	"sync/atomic"
 )
 
+// MaxTasksPerWakeup 每次唤醒最多执行的任务数，剩余任务留到下一轮，避免任务过多导致 IO 事件饥饿
+const MaxTasksPerWakeup = 256
+
+// TaskFunc 投递到 eventloop 中执行的任务
+type TaskFunc func(arg interface{}) error
+
+type task struct {
+	fn  TaskFunc
+	arg interface{}
+}
+
 // Epoll epoll 封装
 type Epoll struct {
 	// epfd，epoll_create()产生的唯一标识epoll对象的文件描述符
@@ -16,7 +30,20 @@ type Epoll struct {
 	// eventfd 对应文件内容buf，避免重复开辟空间
 	eventfdBuf []byte
 
-	taskQueue []func(interface{}) error
+	// taskMu 保护 taskQueue 以及 stopped，其他 goroutine 通过 Trigger 投递任务
+	taskMu    sync.Mutex
+	taskQueue []task
+	// running 当前批次正在执行的任务，复用底层数组
+	running []task
+	// wakeup 标记 eventfd 是否已经被写入且尚未处理，避免重复的 write 系统调用
+	wakeup int32
+	// stopped 不再接受新的任务，eventfd 的写入也在 taskMu 中完成，Stop 之后 eventfd 才会被关闭，不会写入被复用的 fd
+	stopped bool
+	// closed 标记 epfd 以及 eventfd 是否已经关闭
+	closed int32
+
+	// timers 定时器最小堆，决定 EpollWait 的超时时间，只在 Polling 所在的 goroutine 中访问
+	timers timerHeap
 }
 
 type EventType = uint32
@@ -63,16 +90,17 @@ func CreateEpoll() (*Epoll, error) {
 	return epoll, nil
 }
 
-// Polling 阻塞在EpollWait，等待事件就绪后调用callback
+// Polling 阻塞在EpollWait，等待事件就绪后调用callback，有定时器时最多阻塞到最近的定时器到期。
+// callback 或者任务返回 errors.ErrServerShutdown 时退出循环并返回 nil
 func (ep *Epoll) Polling(callback func(fd int, eventType EventType) error) error {
 	events := make([]unix.EpollEvent, 1024)
 	for {
-		// 阻塞直到有事件就绪
-		numPolled, err := unix.EpollWait(ep.epfd, events, -1)
+		// 阻塞直到有事件就绪或者定时器到期
+		numPolled, err := unix.EpollWait(ep.epfd, events, ep.timeout())
 		// EINTR https://man7.org/linux/man-pages/man2/epoll_wait.2.html
 		if err != nil && err != unix.EINTR {
 			log.Printf("epollwait error, %v \n", err)
-			continue
+			return err
 		}
 
 		var runTask bool
@@ -82,21 +110,127 @@ func (ep *Epoll) Polling(callback func(fd int, eventType EventType) error) error
 			if pfd := int(ev.Fd); pfd != ep.eventfd { // io事件就绪，非内部任务
 				// ev.Events 是一个 bitmask， 可能出现的事件： https://man7.org/linux/man-pages/man2/epoll_ctl.2.html
 				if err := callback(pfd, ev.Events); err != nil {
+					if err == errors.ErrServerShutdown {
+						return nil
+					}
 					log.Printf("callback error, %v \n", err)
 					continue
 				}
 			} else { // WakeUp 主动唤醒，执行内部任务，比如定时任务之类
 				// 将 eventfd 中的数据读取出来清零，解除读就绪事件，避免 epoll 被重复唤醒，早期 evio 有这个bug
 				_, _ = unix.Read(ep.eventfd, ep.eventfdBuf)
-				// log.Printf("eventfd buf : %v \n", ep.eventfdBuf)
 				runTask = true
 			}
 		}
 
 		if runTask {
-			log.Println("run all task !")
+			if err := ep.runTasks(); err == errors.ErrServerShutdown {
+				return nil
+			}
+		}
+
+		if err := ep.runTimers(); err == errors.ErrServerShutdown {
+			return nil
+		}
+	}
+}
+
+// Trigger 投递一个任务到 eventloop，任务会在 Polling 所在的 goroutine 中执行，可以在任意 goroutine 中调用
+func (ep *Epoll) Trigger(fn TaskFunc, arg interface{}) error {
+	ep.taskMu.Lock()
+	defer ep.taskMu.Unlock()
+	if ep.stopped {
+		return errors.ErrEventLoopClosed
+	}
+	ep.taskQueue = append(ep.taskQueue, task{fn: fn, arg: arg})
+	return ep.wakeUpOnce()
+}
+
+// Stop 不再接受新的任务，之后 Trigger 返回 errors.ErrEventLoopClosed，并在当前 goroutine 中执行队列中剩余的任务，
+// 已经投递的任务（例如注册连接、Dial 的回调）不会丢失。在 Polling 退出之后调用，Close 会先调用 Stop
+func (ep *Epoll) Stop() {
+	ep.taskMu.Lock()
+	if ep.stopped {
+		ep.taskMu.Unlock()
+		return
+	}
+	ep.stopped = true
+	pending := ep.taskQueue
+	ep.taskQueue = nil
+	ep.taskMu.Unlock()
+
+	for _, t := range pending {
+		// 已经不在 Polling 中，ErrServerShutdown 没有意义，直接忽略
+		if err := t.fn(t.arg); err != nil && err != errors.ErrServerShutdown {
+			log.Printf("run task error, %v \n", err)
+		}
+	}
+}
+
+// QueuedTasks 等待执行的任务数
+func (ep *Epoll) QueuedTasks() int {
+	ep.taskMu.Lock()
+	defer ep.taskMu.Unlock()
+	return len(ep.taskQueue)
+}
+
+// wakeUpOnce eventfd 还未被写入时才唤醒，多次 Trigger 只需要一次系统调用，调用方需要持有 taskMu
+func (ep *Epoll) wakeUpOnce() error {
+	if atomic.CompareAndSwapInt32(&ep.wakeup, 0, 1) {
+		if err := ep.WakeUp(); err != nil && err != unix.EAGAIN {
+			atomic.StoreInt32(&ep.wakeup, 0)
+			return err
+		}
+	}
+	return nil
+}
+
+// runTasks 执行队列中的任务，单次最多执行 MaxTasksPerWakeup 个，剩余的任务重新唤醒 eventloop 等待下一轮。
+// 任务返回 errors.ErrServerShutdown 时执行完当前批次后返回该错误
+func (ep *Epoll) runTasks() error {
+	// 先清除唤醒标记，执行期间投递的任务会重新写入 eventfd
+	atomic.StoreInt32(&ep.wakeup, 0)
+
+	ep.taskMu.Lock()
+	n := len(ep.taskQueue)
+	if n > MaxTasksPerWakeup {
+		n = MaxTasksPerWakeup
+	}
+	ep.running = append(ep.running[:0], ep.taskQueue[:n]...)
+	remain := copy(ep.taskQueue, ep.taskQueue[n:])
+	// 清空尾部引用，避免闭包无法被回收
+	for i := remain; i < len(ep.taskQueue); i++ {
+		ep.taskQueue[i] = task{}
+	}
+	ep.taskQueue = ep.taskQueue[:remain]
+	ep.taskMu.Unlock()
+
+	var shutdown error
+	for i := range ep.running {
+		t := ep.running[i]
+		ep.running[i] = task{}
+		if err := t.fn(t.arg); err != nil {
+			if err == errors.ErrServerShutdown {
+				shutdown = err
+				continue
+			}
+			log.Printf("run task error, %v \n", err)
+		}
+	}
+	if shutdown != nil {
+		return shutdown
+	}
+
+	if remain > 0 {
+		ep.taskMu.Lock()
+		if !ep.stopped {
+			if err := ep.wakeUpOnce(); err != nil {
+				log.Printf("wakeup error, %v \n", err)
+			}
 		}
+		ep.taskMu.Unlock()
 	}
+	return nil
 }
 
 // WakeUp 主动唤醒eventloop，执行任务（非IO事件任务）
@@ -167,7 +301,12 @@ func (ep *Epoll) Delete(fd int) error {
 	return unix.EpollCtl(ep.epfd, unix.EPOLL_CTL_DEL, fd, nil)
 }
 
+// Close 执行剩余的任务并关闭 epfd 以及 eventfd
 func (ep *Epoll) Close() error {
+	ep.Stop()
+	if !atomic.CompareAndSwapInt32(&ep.closed, 0, 1) {
+		return nil
+	}
 	if err := unix.Close(ep.epfd); err != nil {
 		return err
 	}
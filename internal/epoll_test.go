@@ -1,7 +1,9 @@
 package internal
 
 import (
-	"fmt"
+	"github.com/imlgw/jinx/errors"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -12,23 +14,138 @@ func TestEpoll_Polling(t *testing.T) {
 		t.Fatal("create epoll fail")
 	}
 
+	var called int32
 	go func() {
-		epoll.Polling(func(fd int, eventType EventType) error {
-			t.Fatal("callback shouldn't exec")
+		_ = epoll.Polling(func(fd int, eventType EventType) error {
+			atomic.StoreInt32(&called, 1)
 			return nil
 		})
 	}()
 
-	time.Sleep(time.Second * 3)
+	time.Sleep(time.Second)
 
 	if err := epoll.WakeUp(); err != nil {
-		fmt.Println(err)
-		t.Fatal("wakeUp fail")
+		t.Fatalf("wakeUp fail, %v", err)
 	}
 
-	time.Sleep(time.Second * 3)
+	time.Sleep(time.Second)
+
+	if atomic.LoadInt32(&called) != 0 {
+		t.Fatal("callback shouldn't exec")
+	}
 
 	if err := epoll.Close(); err != nil {
 		t.Fatal("epoll close fail!")
 	}
 }
+
+func TestEpoll_Trigger(t *testing.T) {
+	epoll, err := CreateEpoll()
+	if err != nil {
+		t.Fatal("create epoll fail")
+	}
+	defer epoll.Close()
+
+	go func() {
+		_ = epoll.Polling(func(fd int, eventType EventType) error { return nil })
+	}()
+
+	const producers, perProducer = 8, 1000
+	var (
+		wg  sync.WaitGroup
+		cnt int // 只在 eventloop goroutine 中修改，不需要加锁
+	)
+	wg.Add(producers * perProducer)
+	for i := 0; i < producers; i++ {
+		go func() {
+			for j := 0; j < perProducer; j++ {
+				if err := epoll.Trigger(func(interface{}) error {
+					cnt++
+					wg.Done()
+					return nil
+				}, nil); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("tasks not finished in time")
+	}
+
+	ch := make(chan int)
+	_ = epoll.Trigger(func(interface{}) error {
+		ch <- cnt
+		return nil
+	}, nil)
+	if n := <-ch; n != producers*perProducer {
+		t.Fatalf("expected %d tasks, got %d", producers*perProducer, n)
+	}
+}
+
+func TestEpoll_RunTasksLimit(t *testing.T) {
+	epoll, err := CreateEpoll()
+	if err != nil {
+		t.Fatal("create epoll fail")
+	}
+	defer epoll.Close()
+
+	var cnt int
+	total := MaxTasksPerWakeup*2 + 10
+	for i := 0; i < total; i++ {
+		_ = epoll.Trigger(func(interface{}) error {
+			cnt++
+			return nil
+		}, nil)
+	}
+
+	// 每次唤醒最多执行 MaxTasksPerWakeup 个任务
+	epoll.runTasks()
+	if cnt != MaxTasksPerWakeup {
+		t.Fatalf("expected %d tasks run, got %d", MaxTasksPerWakeup, cnt)
+	}
+	if q := epoll.QueuedTasks(); q != total-MaxTasksPerWakeup {
+		t.Fatalf("expected %d tasks queued, got %d", total-MaxTasksPerWakeup, q)
+	}
+
+	epoll.runTasks()
+	epoll.runTasks()
+	if cnt != total || epoll.QueuedTasks() != 0 {
+		t.Fatalf("expected all %d tasks run, got %d", total, cnt)
+	}
+}
+
+func TestEpoll_Stop(t *testing.T) {
+	epoll, err := CreateEpoll()
+	if err != nil {
+		t.Fatal("create epoll fail")
+	}
+
+	// 没有被 Polling 执行的任务在 Close 时执行，不会丢失
+	var cnt int
+	for i := 0; i < 3; i++ {
+		if err := epoll.Trigger(func(interface{}) error {
+			cnt++
+			return nil
+		}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := epoll.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if cnt != 3 {
+		t.Fatalf("expected 3 tasks run on close, got %d", cnt)
+	}
+	if err := epoll.Trigger(func(interface{}) error { return nil }, nil); err != errors.ErrEventLoopClosed {
+		t.Fatalf("expected %v, got %v", errors.ErrEventLoopClosed, err)
+	}
+}
@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"math/bits"
+	"sync"
+)
+
+const (
+	// minChunkShift 最小块 512B，maxChunkShift 最大块 64KiB，更大的数据拆分为多个块
+	minChunkShift = 9
+	maxChunkShift = 16
+	maxChunkSize  = 1 << maxChunkShift
+)
+
+// chunkPools 按照 2 的幂划分大小等级的内存池，下标 i 对应 1 << (i + minChunkShift) 字节
+var chunkPools [maxChunkShift - minChunkShift + 1]sync.Pool
+
+// getChunk 从内存池获取不小于 size 的块（不超过 maxChunkSize）
+func getChunk(size int) []byte {
+	if size > maxChunkSize {
+		size = maxChunkSize
+	}
+	shift := minChunkShift
+	if size > 1<<minChunkShift {
+		shift = bits.Len(uint(size - 1))
+	}
+	if b, ok := chunkPools[shift-minChunkShift].Get().(*[]byte); ok {
+		return (*b)[:0]
+	}
+	return make([]byte, 0, 1<<shift)
+}
+
+// putChunk 归还块到内存池，容量不是内存池中的大小等级时直接丢弃
+func putChunk(b []byte) {
+	c := cap(b)
+	if c < 1<<minChunkShift || c > maxChunkSize || c&(c-1) != 0 {
+		return
+	}
+	b = b[:0]
+	chunkPools[bits.Len(uint(c))-1-minChunkShift].Put(&b)
+}
+
+type chunk struct {
+	buf  []byte // 从内存池获取的块，buf[off:] 为未写出的数据
+	off  int
+	next *chunk
+}
+
+// LinkedBuffer 由内存池中的块组成的链表，用作连接的 outBuffer。
+// 写入时不需要扩容拷贝已有数据，数据写出后块立即归还内存池。不是并发安全的
+type LinkedBuffer struct {
+	head, tail *chunk
+	size       int
+}
+
+// Len 未写出的数据长度
+func (lb *LinkedBuffer) Len() int { return lb.size }
+
+// IsEmpty 是否没有未写出的数据
+func (lb *LinkedBuffer) IsEmpty() bool { return lb.size == 0 }
+
+// Write 拷贝 p 到链表尾部，优先填满最后一个块的剩余空间
+func (lb *LinkedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if lb.tail != nil {
+		free := cap(lb.tail.buf) - len(lb.tail.buf)
+		if free > len(p) {
+			free = len(p)
+		}
+		lb.tail.buf = append(lb.tail.buf, p[:free]...)
+		p = p[free:]
+	}
+	for len(p) > 0 {
+		c := &chunk{buf: getChunk(len(p))}
+		size := cap(c.buf)
+		if size > len(p) {
+			size = len(p)
+		}
+		c.buf = append(c.buf, p[:size]...)
+		p = p[size:]
+		if lb.tail == nil {
+			lb.head = c
+		} else {
+			lb.tail.next = c
+		}
+		lb.tail = c
+	}
+	lb.size += n
+	return n, nil
+}
+
+// Peek 返回第一个块中未写出的数据
+func (lb *LinkedBuffer) Peek() []byte {
+	if lb.head == nil {
+		return nil
+	}
+	return lb.head.buf[lb.head.off:]
+}
+
+// PeekBuffers 将最多 max 个块中未写出的数据追加到 bufs 并返回，用于 writev 一次写出多个块
+func (lb *LinkedBuffer) PeekBuffers(bufs [][]byte, max int) [][]byte {
+	for c := lb.head; c != nil && max > 0; c = c.next {
+		bufs = append(bufs, c.buf[c.off:])
+		max--
+	}
+	return bufs
+}
+
+// Discard 丢弃前 n 个字节（已经写出的数据），写完的块归还内存池
+func (lb *LinkedBuffer) Discard(n int) {
+	for n > 0 && lb.head != nil {
+		c := lb.head
+		remain := len(c.buf) - c.off
+		if n < remain {
+			c.off += n
+			lb.size -= n
+			return
+		}
+		n -= remain
+		lb.size -= remain
+		lb.head = c.next
+		putChunk(c.buf)
+		c.buf, c.next = nil, nil
+	}
+	if lb.head == nil {
+		lb.tail = nil
+	}
+}
+
+// Release 丢弃所有数据并归还内存池
+func (lb *LinkedBuffer) Release() {
+	lb.Discard(lb.size)
+	lb.head, lb.tail, lb.size = nil, nil, 0
+}
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLinkedBuffer(t *testing.T) {
+	var lb LinkedBuffer
+	if !lb.IsEmpty() || lb.Peek() != nil {
+		t.Fatal("new buffer should be empty")
+	}
+
+	data := make([]byte, maxChunkSize*2+100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	_, _ = lb.Write(data[:10])
+	_, _ = lb.Write(data[10:])
+	if lb.Len() != len(data) {
+		t.Fatalf("expected len %d, got %d", len(data), lb.Len())
+	}
+
+	// 模拟 TCP 写半包，每次只写出一部分
+	var out []byte
+	for !lb.IsEmpty() {
+		p := lb.Peek()
+		if len(p) > maxChunkSize {
+			t.Fatalf("chunk too large: %d", len(p))
+		}
+		n := len(p)/2 + 1
+		out = append(out, p[:n]...)
+		lb.Discard(n)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("data mismatch")
+	}
+	if lb.head != nil || lb.tail != nil {
+		t.Fatal("chunks should be released")
+	}
+
+	_, _ = lb.Write([]byte("hello"))
+	lb.Release()
+	if !lb.IsEmpty() || lb.Peek() != nil {
+		t.Fatal("buffer should be empty after release")
+	}
+}
+
+func TestChunkPool(t *testing.T) {
+	cases := []struct{ size, cap int }{
+		{1, 1 << minChunkShift},
+		{1 << minChunkShift, 1 << minChunkShift},
+		{1<<minChunkShift + 1, 1 << (minChunkShift + 1)},
+		{maxChunkSize * 4, maxChunkSize},
+	}
+	for _, c := range cases {
+		b := getChunk(c.size)
+		if len(b) != 0 || cap(b) != c.cap {
+			t.Fatalf("getChunk(%d): expected cap %d, got len %d cap %d", c.size, c.cap, len(b), cap(b))
+		}
+		putChunk(b)
+	}
+	// 不属于任何大小等级的块直接丢弃
+	putChunk(make([]byte, 1000))
+}
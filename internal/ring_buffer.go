@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"github.com/imlgw/jinx/errors"
+)
+
+// RingBuffer 弹性环形缓冲区，容量在 [min, max] 之间按需扩容，数据读空后缩容回 min。
+// 不是并发安全的，只能在 eventloop 的 goroutine 中使用
+type RingBuffer struct {
+	buf []byte
+	// r 读位置，w 写位置，size 已缓存的数据长度（r == w 时通过 size 区分空和满）
+	r, w, size int
+	min, max   int
+}
+
+// NewRingBuffer 创建环形缓冲区，max <= 0 表示不限制容量。底层数组在第一次写入时才分配，空闲连接不占用内存
+func NewRingBuffer(min, max int) *RingBuffer {
+	if min <= 0 {
+		min = 1024
+	}
+	if max > 0 && max < min {
+		max = min
+	}
+	return &RingBuffer{min: min, max: max}
+}
+
+// Len 已缓存的数据长度
+func (rb *RingBuffer) Len() int { return rb.size }
+
+// Cap 当前容量
+func (rb *RingBuffer) Cap() int { return len(rb.buf) }
+
+// Free 不扩容的情况下可写入的长度
+func (rb *RingBuffer) Free() int { return len(rb.buf) - rb.size }
+
+// Write 写入 p，空间不足时扩容，超过 max 返回 ErrBufferFull 且不写入任何数据
+func (rb *RingBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if n == 0 {
+		return 0, nil
+	}
+	if n > rb.Free() {
+		if err := rb.grow(rb.size + n); err != nil {
+			return 0, err
+		}
+	}
+	// 先写到数组末尾，剩余的绕回到开头
+	c := copy(rb.buf[rb.w:], p)
+	if c < n {
+		copy(rb.buf, p[c:])
+	}
+	rb.w = (rb.w + n) % len(rb.buf)
+	rb.size += n
+	return n, nil
+}
+
+// Peek 返回前 n 个字节但不移动读位置，n <= 0 或者大于 Len 时返回全部数据。
+// 数据跨越数组末尾时分为 head 和 tail 两段，否则 tail 为 nil
+func (rb *RingBuffer) Peek(n int) (head []byte, tail []byte) {
+	if n <= 0 || n > rb.size {
+		n = rb.size
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	if rb.r+n <= len(rb.buf) {
+		return rb.buf[rb.r : rb.r+n], nil
+	}
+	head = rb.buf[rb.r:]
+	return head, rb.buf[:n-len(head)]
+}
+
+// Discard 丢弃前 n 个字节，返回实际丢弃的长度
+func (rb *RingBuffer) Discard(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	if n >= rb.size {
+		n = rb.size
+		rb.Reset()
+		return n
+	}
+	rb.r = (rb.r + n) % len(rb.buf)
+	rb.size -= n
+	return n
+}
+
+// Read 读取数据到 p 并移动读位置
+func (rb *RingBuffer) Read(p []byte) (int, error) {
+	head, tail := rb.Peek(len(p))
+	n := copy(p, head)
+	n += copy(p[n:], tail)
+	rb.Discard(n)
+	return n, nil
+}
+
+// Reset 清空数据，容量超过 min 时缩容
+func (rb *RingBuffer) Reset() {
+	rb.r, rb.w, rb.size = 0, 0, 0
+	if len(rb.buf) > rb.min {
+		rb.buf = make([]byte, rb.min)
+	}
+}
+
+// grow 扩容到能够容纳 need 个字节，新容量为不小于 need 的 2 的幂，且不超过 max
+func (rb *RingBuffer) grow(need int) error {
+	if rb.max > 0 && need > rb.max {
+		return errors.ErrBufferFull
+	}
+	newCap := rb.min
+	for newCap < need {
+		newCap <<= 1
+	}
+	if rb.max > 0 && newCap > rb.max {
+		newCap = rb.max
+	}
+	buf := make([]byte, newCap)
+	// 整理数据到新数组的开头
+	head, tail := rb.Peek(0)
+	n := copy(buf, head)
+	n += copy(buf[n:], tail)
+	rb.buf = buf
+	rb.r, rb.w = 0, n%newCap
+	return nil
+}
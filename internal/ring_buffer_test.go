@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"bytes"
+	"github.com/imlgw/jinx/errors"
+	"math/rand"
+	"testing"
+)
+
+func TestRingBuffer(t *testing.T) {
+	rb := NewRingBuffer(8, 64)
+	if rb.Cap() != 0 {
+		t.Fatal("buffer should be allocated lazily")
+	}
+
+	_, _ = rb.Write([]byte("hello"))
+	if rb.Len() != 5 || rb.Cap() != 8 {
+		t.Fatalf("unexpected len %d cap %d", rb.Len(), rb.Cap())
+	}
+	rb.Discard(3)
+	// 写入跨越数组末尾
+	_, _ = rb.Write([]byte("world"))
+	head, tail := rb.Peek(0)
+	if tail == nil || string(head)+string(tail) != "loworld" {
+		t.Fatalf("unexpected data %q %q", head, tail)
+	}
+
+	// 扩容后数据保持顺序
+	_, _ = rb.Write([]byte("0123456789"))
+	if rb.Cap() != 32 {
+		t.Fatalf("unexpected cap %d", rb.Cap())
+	}
+	head, tail = rb.Peek(0)
+	if string(head)+string(tail) != "loworld0123456789" {
+		t.Fatalf("unexpected data %q %q", head, tail)
+	}
+
+	// 超过 max
+	if _, err := rb.Write(make([]byte, 64)); err != errors.ErrBufferFull {
+		t.Fatalf("expected ErrBufferFull, got %v", err)
+	}
+	if rb.Len() != 17 {
+		t.Fatalf("failed write shouldn't change data, len %d", rb.Len())
+	}
+
+	// 读空后缩容
+	buf := make([]byte, 64)
+	n, _ := rb.Read(buf)
+	if string(buf[:n]) != "loworld0123456789" || rb.Len() != 0 || rb.Cap() != 8 {
+		t.Fatalf("unexpected read %q, len %d cap %d", buf[:n], rb.Len(), rb.Cap())
+	}
+}
+
+func TestRingBufferRandom(t *testing.T) {
+	rb := NewRingBuffer(16, 0)
+	var expect bytes.Buffer
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		if r.Intn(2) == 0 {
+			p := make([]byte, r.Intn(100))
+			r.Read(p)
+			_, _ = rb.Write(p)
+			expect.Write(p)
+		} else {
+			n := 1 + r.Intn(120)
+			head, tail := rb.Peek(n)
+			got := append(append([]byte(nil), head...), tail...)
+			want := expect.Next(n)
+			if !bytes.Equal(got, want) {
+				t.Fatalf("round %d: data mismatch", i)
+			}
+			rb.Discard(n)
+		}
+		if rb.Len() != expect.Len() {
+			t.Fatalf("round %d: len %d, expected %d", i, rb.Len(), expect.Len())
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"golang.org/x/sys/unix"
+	"net"
+)
+
+// SocketConnect 创建非阻塞套接字并发起连接，支持 tcp，tcp4，tcp6 以及 unix。
+// 连接通常不会立即完成（EINPROGRESS），需要等待套接字可写后通过 SocketError 获取连接结果
+func SocketConnect(network, addr string) (int, unix.Sockaddr, net.Addr, error) {
+	var (
+		family int
+		sa     unix.Sockaddr
+		raddr  net.Addr
+	)
+	switch network {
+	case "unix":
+		family, sa, raddr = unix.AF_UNIX, &unix.SockaddrUnix{Name: addr}, &net.UnixAddr{Name: addr, Net: network}
+	default:
+		tcpAddr, err := net.ResolveTCPAddr(network, addr)
+		if err != nil {
+			return -1, nil, nil, err
+		}
+		if tcpAddr.IP == nil || tcpAddr.IP.IsUnspecified() {
+			// 与 net.Dial 一致，没有指定 IP 时连接本机
+			tcpAddr.IP = net.IPv4(127, 0, 0, 1)
+			if network == "tcp6" {
+				tcpAddr.IP = net.IPv6loopback
+			}
+		}
+		family, sa, err = ipSockaddr(network, tcpAddr.IP, tcpAddr.Port, tcpAddr.Zone)
+		if err != nil {
+			return -1, nil, nil, err
+		}
+		raddr = tcpAddr
+	}
+
+	fd, err := unix.Socket(family, unix.SOCK_STREAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, nil, nil, err
+	}
+	// 非阻塞 connect 返回 EINPROGRESS 表示正在建立连接，本地连接（例如 unix domain socket）可能直接成功
+	if err = unix.Connect(fd, sa); err != nil && err != unix.EINPROGRESS {
+		_ = unix.Close(fd)
+		return -1, nil, nil, err
+	}
+	return fd, sa, raddr, nil
+}
+
+// SocketError 获取并清除套接字上的错误（SO_ERROR），非阻塞 connect 完成后用来判断连接是否成功
+func SocketError(fd int) error {
+	errno, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR)
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return unix.Errno(errno)
+	}
+	return nil
+}
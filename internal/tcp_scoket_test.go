@@ -3,8 +3,10 @@ package internal
 import (
 	"golang.org/x/sys/unix"
 	"net"
+	"strconv"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestSocketBind(t *testing.T) {
@@ -13,7 +15,9 @@ func TestSocketBind(t *testing.T) {
 	go func() {
 		lnfd, _, err := SocketListen("tcp", ":9876")
 		if err != nil {
-			t.Fatal(err)
+			t.Error(err)
+			wg.Done()
+			return
 		}
 		wg.Done()
 		connfd, _, err := unix.Accept(lnfd)
@@ -21,7 +25,7 @@ func TestSocketBind(t *testing.T) {
 		// unix.Read(connfd, buf)
 		// fmt.Println("recv:", string(buf))
 		if n, err := unix.Read(connfd, buf); err != nil || string(buf[:n]) != "Resolmi" {
-			t.Fatal(err)
+			t.Error(err)
 		}
 		wg.Done()
 	}()
@@ -39,3 +43,86 @@ func TestSocketBind(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestSocketListenIPv6(t *testing.T) {
+	tests := []struct {
+		network, addr string
+		opts          []SocketOption
+		dial          string
+		v4Ok          bool
+	}{
+		{network: "tcp6", addr: "[::1]:0", dial: "tcp6"},
+		{network: "tcp", addr: ":0", dial: "tcp6", v4Ok: true},
+		{network: "tcp", addr: ":0", opts: []SocketOption{WithIPv6Only(true)}, dial: "tcp6"},
+		{network: "tcp", addr: "127.0.0.1:0", dial: "tcp4", v4Ok: true},
+	}
+
+	for _, tt := range tests {
+		lnfd, addr, err := SocketListen(tt.network, tt.addr, tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := addr.(*net.TCPAddr).Port
+		if port == 0 {
+			t.Fatalf("%s %s: listen port not resolved", tt.network, tt.addr)
+		}
+
+		host := "::1"
+		if tt.dial == "tcp4" {
+			host = "127.0.0.1"
+		}
+		conn, err := net.Dial(tt.dial, net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			t.Fatalf("%s %s: %v", tt.network, tt.addr, err)
+		}
+		connfd, sa, err := unix.Accept(lnfd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		remote := SockaddrToTCPOrUnixAddr(sa).(*net.TCPAddr)
+		if remote.Port != conn.LocalAddr().(*net.TCPAddr).Port || !remote.IP.Equal(conn.LocalAddr().(*net.TCPAddr).IP) {
+			t.Fatalf("%s %s: remote addr %v, expected %v", tt.network, tt.addr, remote, conn.LocalAddr())
+		}
+		_ = conn.Close()
+		_ = unix.Close(connfd)
+
+		// 只有 dual-stack 或者 IPv4 监听才能接收 IPv4 连接
+		conn, err = net.DialTimeout("tcp4", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), time.Second)
+		if (err == nil) != tt.v4Ok {
+			t.Fatalf("%s %s %v: ipv4 dial err %v", tt.network, tt.addr, tt.opts, err)
+		}
+		if conn != nil {
+			_ = conn.Close()
+		}
+		_ = unix.Close(lnfd)
+	}
+}
+
+func TestSockaddrInet6Zone(t *testing.T) {
+	ifis, err := net.Interfaces()
+	if err != nil || len(ifis) == 0 {
+		t.Skip("no interface")
+	}
+	sa := &unix.SockaddrInet6{Port: 80, ZoneId: uint32(ifis[0].Index)}
+	copy(sa.Addr[:], net.ParseIP("fe80::1"))
+	addr := SockaddrToTCPOrUnixAddr(sa).(*net.TCPAddr)
+	if addr.Zone != ifis[0].Name || addr.String() != "[fe80::1%"+ifis[0].Name+"]:80" {
+		t.Fatalf("unexpected addr %v", addr)
+	}
+
+	_, inet6, err := ipSockaddr("tcp6", addr.IP, addr.Port, addr.Zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inet6.(*unix.SockaddrInet6).ZoneId != uint32(ifis[0].Index) {
+		t.Fatalf("unexpected zone id %d", inet6.(*unix.SockaddrInet6).ZoneId)
+	}
+	// 网卡序号作为 zone
+	_, inet6, err = ipSockaddr("tcp6", addr.IP, addr.Port, strconv.Itoa(ifis[0].Index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inet6.(*unix.SockaddrInet6).ZoneId != uint32(ifis[0].Index) {
+		t.Fatalf("unexpected zone id %d", inet6.(*unix.SockaddrInet6).ZoneId)
+	}
+}
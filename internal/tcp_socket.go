@@ -3,15 +3,55 @@ package internal
 import (
 	"golang.org/x/sys/unix"
 	"net"
+	"os"
+	"strconv"
 )
 
+// SocketOption 创建监听套接字时的可选配置
+type SocketOption func(opts *socketOptions)
+
+type socketOptions struct {
+	// ipv6Only 是否只接收 IPv6 连接，只对 AF_INET6 生效
+	ipv6Only bool
+	// socketMode unix domain socket 文件的权限，为 0 时使用 umask 决定的默认权限
+	socketMode os.FileMode
+	// reusePort 设置 SO_REUSEPORT，多个套接字绑定同一个地址，由内核分发连接或者数据报
+	reusePort bool
+}
+
+// WithIPv6Only 设置 IPV6_V6ONLY，为 false 时 "tcp" 网络监听通配地址会同时接收 IPv4 和 IPv6 连接（dual-stack）
+func WithIPv6Only(ipv6Only bool) SocketOption {
+	return func(opts *socketOptions) {
+		opts.ipv6Only = ipv6Only
+	}
+}
+
+// WithSocketMode 设置 unix domain socket 文件的权限
+func WithSocketMode(mode os.FileMode) SocketOption {
+	return func(opts *socketOptions) {
+		opts.socketMode = mode
+	}
+}
+
+// WithReusePort 设置 SO_REUSEPORT
+func WithReusePort(reusePort bool) SocketOption {
+	return func(opts *socketOptions) {
+		opts.reusePort = reusePort
+	}
+}
+
 // SocketListen 参考：https://zhuanlan.zhihu.com/p/399651675
-func SocketListen(network, addr string) (int, *net.TCPAddr, error) {
-	// 创建一个 socketfd，暂时只支持 tcp4
-	// https://man7.org/linux/man-pages/man2/socket.2.html
-	socketfd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, unix.IPPROTO_TCP)
-	if err != nil {
-		return -1, nil, err
+// 支持 tcp，tcp4，tcp6 以及 unix。返回监听套接字以及实际绑定的地址（端口为 0 时由内核分配）
+func SocketListen(network, addr string, opts ...SocketOption) (int, net.Addr, error) {
+	options := new(socketOptions)
+	for _, opt := range opts {
+		opt(options)
+	}
+	switch network {
+	case "unix":
+		return unixSocketListen(addr, options)
+	case "udp", "udp4", "udp6":
+		return udpSocketListen(network, addr, options)
 	}
 
 	// 解析地址
@@ -20,20 +60,171 @@ func SocketListen(network, addr string) (int, *net.TCPAddr, error) {
 		return -1, nil, err
 	}
 
-	// 转换为 unix.SockaddrInet4 用于 bind()
-	inet4 := &unix.SockaddrInet4{Port: tcpAddr.Port}
-	copy(inet4.Addr[:], tcpAddr.IP)
+	// 创建一个 socketfd
+	// https://man7.org/linux/man-pages/man2/socket.2.html
+	socketfd, family, sa, err := ipSocket(network, tcpAddr.IP, tcpAddr.Port, tcpAddr.Zone, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, unix.IPPROTO_TCP)
+	if err != nil {
+		return -1, nil, err
+	}
+
+	// 设置 SO_REUSEADDR，服务重启时可以直接绑定处于 TIME_WAIT 状态的地址
+	if err = unix.SetsockoptInt(socketfd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+		_ = unix.Close(socketfd)
+		return -1, nil, err
+	}
+
+	if err = setSockopts(socketfd, family, network, options); err != nil {
+		_ = unix.Close(socketfd)
+		return -1, nil, err
+	}
 
 	// 绑定 socketfd 和地址，https://man7.org/linux/man-pages/man2/bind.2.html
-	if err = unix.Bind(socketfd, inet4); err != nil {
+	if err = unix.Bind(socketfd, sa); err != nil {
+		_ = unix.Close(socketfd)
 		return -1, nil, err
 	}
 
 	// 转换为监听套接字 https://man7.org/linux/man-pages/man2/listen.2.html
 	// 第二个参数为「全连接队列长度」--> /proc/sys/net/core/somaxconn 默认4096
 	if err = unix.Listen(socketfd, unix.SOMAXCONN); err != nil {
+		_ = unix.Close(socketfd)
 		return -1, nil, err
 	}
 
-	return socketfd, tcpAddr, nil
+	// 获取实际绑定的地址
+	lsa, err := unix.Getsockname(socketfd)
+	if err != nil {
+		_ = unix.Close(socketfd)
+		return -1, nil, err
+	}
+	return socketfd, SockaddrToTCPOrUnixAddr(lsa), nil
+}
+
+// setSockopts 设置 IPV6_V6ONLY 以及 SO_REUSEPORT
+func setSockopts(fd, family int, network string, options *socketOptions) error {
+	if family == unix.AF_INET6 {
+		// tcp6 只接收 IPv6 连接，tcp 默认 dual-stack，IPv4 客户端的地址会被映射为 ::ffff:a.b.c.d
+		v6only := 0
+		if network[len(network)-1] == '6' || options.ipv6Only {
+			v6only = 1
+		}
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_V6ONLY, v6only); err != nil {
+			return err
+		}
+	}
+	if options.reusePort {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// classic BPF 访问辅助数据的偏移，x/sys/unix 中没有导出
+// https://github.com/torvalds/linux/blob/master/include/uapi/linux/filter.h
+// SKF_AD_OFF 为 -0x1000，BPF 指令中 K 是 uint32，按照补码表示
+const (
+	skfAdOff = 0xfffff000
+	skfAdCPU = 36
+)
+
+// AttachReusePortCPUSteering 为 SO_REUSEPORT 组挂载 CBPF 程序，按照处理软中断的 CPU 选择套接字：
+// 返回 cpu % n，即第 cpu % n 个 bind 的套接字。配合网卡队列的 CPU 亲和性可以让连接在同一个 CPU 上处理
+func AttachReusePortCPUSteering(fd, n int) error {
+	prog := []unix.SockFilter{
+		// A = 当前 CPU
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: skfAdOff + skfAdCPU},
+		// A = A % n
+		{Code: unix.BPF_ALU | unix.BPF_MOD | unix.BPF_K, K: uint32(n)},
+		// return A
+		{Code: unix.BPF_RET | unix.BPF_A},
+	}
+	fprog := unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	return unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_REUSEPORT_CBPF, &fprog)
+}
+
+// ipSockaddr 根据 network 以及地址选择协议族，并转换为 bind() 使用的 unix.Sockaddr。
+// network 以 4 结尾使用 AF_INET，以 6 结尾使用 AF_INET6，否则根据地址决定
+func ipSockaddr(network string, ip net.IP, port int, zone string) (int, unix.Sockaddr, error) {
+	ip4 := ip.To4()
+	switch network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
+	default:
+		return 0, nil, net.UnknownNetworkError(network)
+	}
+	suffix := network[len(network)-1]
+	if suffix == '4' || (suffix != '6' && ip4 != nil && !ip.IsUnspecified()) {
+		// 注意 net.IP 解析 IPv4 地址得到的也是 16 字节，需要先 To4 再拷贝
+		inet4 := &unix.SockaddrInet4{Port: port}
+		if ip4 != nil {
+			copy(inet4.Addr[:], ip4)
+		}
+		return unix.AF_INET, inet4, nil
+	}
+
+	// 监听通配地址（包括 0.0.0.0）时使用 AF_INET6 实现 dual-stack
+	inet6 := &unix.SockaddrInet6{Port: port}
+	if ip16 := ip.To16(); ip16 != nil && !ip.IsUnspecified() {
+		copy(inet6.Addr[:], ip16)
+	}
+	if zone != "" {
+		// 与 net 包一致，zone 可以是网卡序号（fe80::1%2）也可以是网卡名称
+		if id, err := strconv.Atoi(zone); err == nil {
+			inet6.ZoneId = uint32(id)
+		} else {
+			ifi, err := net.InterfaceByName(zone)
+			if err != nil {
+				return 0, nil, err
+			}
+			inet6.ZoneId = uint32(ifi.Index)
+		}
+	}
+	return unix.AF_INET6, inet6, nil
+}
+
+// ipSocket 根据 ipSockaddr 选择的协议族创建套接字，typ 为 SOCK_STREAM 或者 SOCK_DGRAM 以及附加的标志位。
+// 通配地址默认使用 AF_INET6，内核不支持 IPv6（例如 ipv6.disable=1）创建失败时退回 AF_INET
+func ipSocket(network string, ip net.IP, port int, zone string, typ, proto int) (int, int, unix.Sockaddr, error) {
+	family, sa, err := ipSockaddr(network, ip, port, zone)
+	if err != nil {
+		return -1, 0, nil, err
+	}
+	fd, err := unix.Socket(family, typ, proto)
+	if err != nil && family == unix.AF_INET6 && network[len(network)-1] != '6' && (ip == nil || ip.IsUnspecified()) {
+		family, sa = unix.AF_INET, &unix.SockaddrInet4{Port: port}
+		fd, err = unix.Socket(family, typ, proto)
+	}
+	if err != nil {
+		return -1, 0, nil, err
+	}
+	return fd, family, sa, nil
+}
+
+// SockaddrToTCPOrUnixAddr 将 unix.Sockaddr 转换为 net.Addr
+func SockaddrToTCPOrUnixAddr(sa unix.Sockaddr) net.Addr {
+	switch sa := (sa).(type) {
+	case *unix.SockaddrInet4:
+		ip := make(net.IP, net.IPv4len)
+		copy(ip, sa.Addr[:])
+		return &net.TCPAddr{IP: ip, Port: sa.Port}
+	case *unix.SockaddrInet6:
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, sa.Addr[:])
+		return &net.TCPAddr{IP: ip, Port: sa.Port, Zone: zoneName(sa.ZoneId)}
+	case *unix.SockaddrUnix:
+		return &net.UnixAddr{Name: sa.Name, Net: "unix"}
+	default:
+		return nil
+	}
+}
+
+// zoneName 将 IPv6 的 scope id 转换为网卡名称，link-local 地址才会有 zone
+func zoneName(zoneID uint32) string {
+	if zoneID == 0 {
+		return ""
+	}
+	if ifi, err := net.InterfaceByIndex(int(zoneID)); err == nil {
+		return ifi.Name
+	}
+	return ""
 }
@@ -0,0 +1,53 @@
+// Package testutil 测试使用的公共函数，只在 _test.go 中引用
+package testutil
+
+import (
+	"testing"
+	"time"
+)
+
+// Server jinx.Server 中启动以及停止的部分，不直接依赖 jinx，jinx 自身的测试也可以使用
+type Server interface {
+	Run() error
+	Started() bool
+	Stop() error
+}
+
+// StartServer 在新的 goroutine 中运行 srv，最多等待 timeout 启动完成，测试结束时调用 Stop 并等待 Run 返回。
+// Run 返回错误或者没有在 timeout 内启动、退出时测试失败。返回的 channel 在 Run 返回之后关闭
+func StartServer(t testing.TB, srv Server, timeout time.Duration) <-chan struct{} {
+	t.Helper()
+	done := make(chan struct{})
+	var runErr error
+	go func() {
+		runErr = srv.Run()
+		close(done)
+	}()
+
+	deadline := time.Now().Add(timeout)
+	for !srv.Started() {
+		select {
+		case <-done:
+			t.Fatalf("server exited before started, %v", runErr)
+		default:
+		}
+		if time.Now().After(deadline) {
+			_ = srv.Stop()
+			t.Fatalf("server not started in %v", timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Cleanup(func() {
+		_ = srv.Stop()
+		select {
+		case <-done:
+			if runErr != nil {
+				t.Errorf("server run error, %v", runErr)
+			}
+		case <-time.After(timeout):
+			t.Errorf("server not stopped in %v", timeout)
+		}
+	})
+	return done
+}
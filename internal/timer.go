@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"container/heap"
+	"github.com/imlgw/jinx/errors"
+	"log"
+	"time"
+)
+
+// Timer eventloop 中的定时器，回调在 Polling 所在的 goroutine 中执行，
+// 与任务一样，回调返回 errors.ErrServerShutdown 时退出 Polling
+type Timer struct {
+	when  int64 // 到期时间，UnixNano
+	fn    func() error
+	index int // 在堆中的下标，-1 表示已经停止或者已经触发
+}
+
+// timerHeap 按照到期时间排序的最小堆，堆顶为最近到期的定时器
+type timerHeap []*Timer
+
+func (h timerHeap) Len() int           { return len(h) }
+func (h timerHeap) Less(i, j int) bool { return h[i].when < h[j].when }
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x interface{}) {
+	t := x.(*Timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
+}
+
+// AddTimer 添加一个在 when 到期的定时器，只能在 Polling 所在的 goroutine 中调用，其他 goroutine 需要通过 Trigger 投递
+func (ep *Epoll) AddTimer(when time.Time, fn func() error) *Timer {
+	t := &Timer{when: when.UnixNano(), fn: fn, index: -1}
+	heap.Push(&ep.timers, t)
+	return t
+}
+
+// ResetTimer 修改定时器的到期时间，已经停止或者已经触发的定时器会重新加入
+func (ep *Epoll) ResetTimer(t *Timer, when time.Time) {
+	t.when = when.UnixNano()
+	if t.index < 0 {
+		heap.Push(&ep.timers, t)
+		return
+	}
+	heap.Fix(&ep.timers, t.index)
+}
+
+// StopTimer 停止定时器，返回 false 表示定时器已经停止或者已经触发
+func (ep *Epoll) StopTimer(t *Timer) bool {
+	if t.index < 0 {
+		return false
+	}
+	heap.Remove(&ep.timers, t.index)
+	return true
+}
+
+// Timers 等待触发的定时器个数
+func (ep *Epoll) Timers() int { return len(ep.timers) }
+
+// timeout 计算 EpollWait 的超时时间（毫秒），没有定时器时返回 -1 一直阻塞
+func (ep *Epoll) timeout() int {
+	if len(ep.timers) == 0 {
+		return -1
+	}
+	d := ep.timers[0].when - time.Now().UnixNano()
+	if d <= 0 {
+		return 0
+	}
+	// 向上取整，避免提前唤醒后定时器还没到期导致空转
+	return int((d + int64(time.Millisecond) - 1) / int64(time.Millisecond))
+}
+
+// runTimers 执行所有已经到期的定时器，定时器返回 errors.ErrServerShutdown 时执行完到期的定时器后返回该错误
+func (ep *Epoll) runTimers() error {
+	if len(ep.timers) == 0 {
+		return nil
+	}
+	var shutdown error
+	now := time.Now().UnixNano()
+	for len(ep.timers) > 0 && ep.timers[0].when <= now {
+		t := heap.Pop(&ep.timers).(*Timer)
+		if err := t.fn(); err != nil {
+			if err == errors.ErrServerShutdown {
+				shutdown = err
+				continue
+			}
+			log.Printf("run timer error, %v \n", err)
+		}
+	}
+	return shutdown
+}
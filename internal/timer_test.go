@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimerHeap(t *testing.T) {
+	epoll, err := CreateEpoll()
+	if err != nil {
+		t.Fatal("create epoll fail")
+	}
+	defer epoll.Close()
+
+	if epoll.timeout() != -1 {
+		t.Fatal("expected -1 timeout without timers")
+	}
+
+	var fired []int
+	now := time.Now()
+	add := func(i int, d time.Duration) *Timer {
+		return epoll.AddTimer(now.Add(d), func() error {
+			fired = append(fired, i)
+			return nil
+		})
+	}
+	add(3, -time.Millisecond)
+	t2 := add(2, -2*time.Millisecond)
+	t1 := add(1, time.Hour)
+	stopped := add(4, -3*time.Millisecond)
+	add(5, time.Hour)
+
+	if !epoll.StopTimer(stopped) || epoll.StopTimer(stopped) {
+		t.Fatal("stop timer should only succeed once")
+	}
+	// 修改到期时间后重新排序
+	epoll.ResetTimer(t1, now.Add(-3*time.Millisecond))
+	if epoll.timeout() != 0 {
+		t.Fatal("expected 0 timeout with expired timers")
+	}
+
+	_ = epoll.runTimers()
+	if len(fired) != 3 || fired[0] != 1 || fired[1] != 2 || fired[2] != 3 {
+		t.Fatalf("unexpected fire order %v", fired)
+	}
+	if epoll.Timers() != 1 {
+		t.Fatalf("expected 1 timer left, got %d", epoll.Timers())
+	}
+	if ms := epoll.timeout(); ms <= 0 || ms > int(time.Hour/time.Millisecond) {
+		t.Fatalf("unexpected timeout %d", ms)
+	}
+
+	// 已经触发的定时器可以重新加入
+	epoll.ResetTimer(t2, now)
+	if epoll.Timers() != 2 {
+		t.Fatalf("expected 2 timers, got %d", epoll.Timers())
+	}
+}
+
+func TestEpoll_PollingTimer(t *testing.T) {
+	epoll, err := CreateEpoll()
+	if err != nil {
+		t.Fatal("create epoll fail")
+	}
+	defer epoll.Close()
+
+	fired := make(chan time.Duration, 1)
+	start := time.Now()
+	// 定时器只能在 Polling 所在的 goroutine 中添加
+	_ = epoll.Trigger(func(interface{}) error {
+		epoll.AddTimer(start.Add(50*time.Millisecond), func() error {
+			fired <- time.Since(start)
+			return nil
+		})
+		return nil
+	}, nil)
+	go func() {
+		_ = epoll.Polling(func(fd int, eventType EventType) error { return nil })
+	}()
+
+	select {
+	case d := <-fired:
+		if d < 50*time.Millisecond {
+			t.Fatalf("timer fired too early, %v", d)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timer not fired")
+	}
+}
@@ -0,0 +1,230 @@
+package internal
+
+import (
+	"golang.org/x/sys/unix"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// udpSocketListen 创建非阻塞的 udp 套接字并绑定地址，支持 udp，udp4，udp6
+func udpSocketListen(network, addr string, options *socketOptions) (int, net.Addr, error) {
+	udpAddr, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return -1, nil, err
+	}
+
+	// udp 套接字直接在 eventloop 中读写，需要设置为非阻塞
+	socketfd, family, sa, err := ipSocket(network, udpAddr.IP, udpAddr.Port, udpAddr.Zone, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, unix.IPPROTO_UDP)
+	if err != nil {
+		return -1, nil, err
+	}
+
+	if err = setSockopts(socketfd, family, network, options); err != nil {
+		_ = unix.Close(socketfd)
+		return -1, nil, err
+	}
+
+	if err = unix.Bind(socketfd, sa); err != nil {
+		_ = unix.Close(socketfd)
+		return -1, nil, err
+	}
+
+	lsa, err := unix.Getsockname(socketfd)
+	if err != nil {
+		_ = unix.Close(socketfd)
+		return -1, nil, err
+	}
+	return socketfd, SockaddrToUDPAddr(lsa), nil
+}
+
+// SockaddrToUDPAddr 将 unix.Sockaddr 转换为 *net.UDPAddr
+func SockaddrToUDPAddr(sa unix.Sockaddr) *net.UDPAddr {
+	switch sa := (sa).(type) {
+	case *unix.SockaddrInet4:
+		ip := make(net.IP, net.IPv4len)
+		copy(ip, sa.Addr[:])
+		return &net.UDPAddr{IP: ip, Port: sa.Port}
+	case *unix.SockaddrInet6:
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, sa.Addr[:])
+		return &net.UDPAddr{IP: ip, Port: sa.Port, Zone: zoneName(sa.ZoneId)}
+	default:
+		return nil
+	}
+}
+
+// UDPAddrToSockaddr 将 *net.UDPAddr 转换为 sendto() 使用的 unix.Sockaddr，
+// family 为 AF_INET6 时 IPv4 地址转换为 ::ffff:a.b.c.d
+func UDPAddrToSockaddr(family int, addr *net.UDPAddr) (unix.Sockaddr, error) {
+	if family == unix.AF_INET {
+		ip4 := addr.IP.To4()
+		if ip4 == nil {
+			return nil, unix.EAFNOSUPPORT
+		}
+		inet4 := &unix.SockaddrInet4{Port: addr.Port}
+		copy(inet4.Addr[:], ip4)
+		return inet4, nil
+	}
+	ip16 := addr.IP.To16()
+	if ip16 == nil {
+		return nil, unix.EAFNOSUPPORT
+	}
+	inet6 := &unix.SockaddrInet6{Port: addr.Port}
+	copy(inet6.Addr[:], ip16)
+	if addr.Zone != "" {
+		ifi, err := net.InterfaceByName(addr.Zone)
+		if err != nil {
+			return nil, err
+		}
+		inet6.ZoneId = uint32(ifi.Index)
+	}
+	return inet6, nil
+}
+
+// SocketFamily 获取套接字的协议族
+func SocketFamily(fd int) (int, error) {
+	return unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_DOMAIN)
+}
+
+// mmsghdr 对应内核的 struct mmsghdr，x/sys/unix 中没有导出
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+}
+
+// MsgBatch recvmmsg/sendmmsg 使用的批量消息，复用内存避免每次系统调用都重新分配
+type MsgBatch struct {
+	Bufs  [][]byte
+	Names []unix.RawSockaddrAny
+	// Lens recvmmsg 后每个消息实际的长度
+	Lens []int
+	hdrs []mmsghdr
+	iovs []unix.Iovec
+}
+
+// NewMsgBatch 创建 n 个消息，每个消息的缓冲区大小为 size
+func NewMsgBatch(n, size int) *MsgBatch {
+	b := &MsgBatch{
+		Bufs:  make([][]byte, n),
+		Names: make([]unix.RawSockaddrAny, n),
+		Lens:  make([]int, n),
+		hdrs:  make([]mmsghdr, n),
+		iovs:  make([]unix.Iovec, n),
+	}
+	for i := range b.Bufs {
+		b.Bufs[i] = make([]byte, size)
+	}
+	return b
+}
+
+// Recvmmsg 一次系统调用读取多个数据报，返回读取到的数据报个数
+func (b *MsgBatch) Recvmmsg(fd int) (int, error) {
+	for i := range b.hdrs {
+		b.iovs[i].Base = &b.Bufs[i][0]
+		b.iovs[i].SetLen(len(b.Bufs[i]))
+		b.hdrs[i] = mmsghdr{}
+		b.hdrs[i].hdr.Name = (*byte)(unsafe.Pointer(&b.Names[i]))
+		b.hdrs[i].hdr.Namelen = uint32(unsafe.Sizeof(b.Names[i]))
+		b.hdrs[i].hdr.Iov = &b.iovs[i]
+		b.hdrs[i].hdr.SetIovlen(1)
+	}
+	n, _, errno := unix.Syscall6(unix.SYS_RECVMMSG, uintptr(fd),
+		uintptr(unsafe.Pointer(&b.hdrs[0])), uintptr(len(b.hdrs)), unix.MSG_DONTWAIT, 0, 0)
+	if errno != 0 {
+		return 0, errnoErr(errno)
+	}
+	for i := 0; i < int(n); i++ {
+		b.Lens[i] = int(b.hdrs[i].len)
+	}
+	return int(n), nil
+}
+
+// Sendmmsg 一次系统调用发送 bufs[i] 到 addrs[i]，返回发送成功的数据报个数
+func (b *MsgBatch) Sendmmsg(fd int, bufs [][]byte, addrs []unix.Sockaddr) (int, error) {
+	n := len(bufs)
+	if n > len(b.hdrs) {
+		n = len(b.hdrs)
+	}
+	for i := 0; i < n; i++ {
+		namelen, err := putRawSockaddr(&b.Names[i], addrs[i])
+		if err != nil {
+			return 0, err
+		}
+		b.hdrs[i] = mmsghdr{}
+		b.hdrs[i].hdr.Name = (*byte)(unsafe.Pointer(&b.Names[i]))
+		b.hdrs[i].hdr.Namelen = namelen
+		if len(bufs[i]) > 0 {
+			b.iovs[i].Base = &bufs[i][0]
+			b.iovs[i].SetLen(len(bufs[i]))
+			b.hdrs[i].hdr.Iov = &b.iovs[i]
+			b.hdrs[i].hdr.SetIovlen(1)
+		}
+	}
+	sent, _, errno := unix.Syscall6(unix.SYS_SENDMMSG, uintptr(fd),
+		uintptr(unsafe.Pointer(&b.hdrs[0])), uintptr(n), unix.MSG_DONTWAIT, 0, 0)
+	if errno != 0 {
+		return 0, errnoErr(errno)
+	}
+	return int(sent), nil
+}
+
+// RawSockaddrToUDPAddr 解析 recvmmsg 返回的对端地址
+func RawSockaddrToUDPAddr(rsa *unix.RawSockaddrAny) *net.UDPAddr {
+	switch rsa.Addr.Family {
+	case unix.AF_INET:
+		pp := (*unix.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		ip := make(net.IP, net.IPv4len)
+		copy(ip, pp.Addr[:])
+		return &net.UDPAddr{IP: ip, Port: ntohs(pp.Port)}
+	case unix.AF_INET6:
+		pp := (*unix.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, pp.Addr[:])
+		return &net.UDPAddr{IP: ip, Port: ntohs(pp.Port), Zone: zoneName(pp.Scope_id)}
+	default:
+		return nil
+	}
+}
+
+// putRawSockaddr 将 unix.Sockaddr 写入 sendmmsg 使用的 RawSockaddrAny
+func putRawSockaddr(rsa *unix.RawSockaddrAny, sa unix.Sockaddr) (uint32, error) {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		pp := (*unix.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		*pp = unix.RawSockaddrInet4{Family: unix.AF_INET, Port: htons(sa.Port), Addr: sa.Addr}
+		return unix.SizeofSockaddrInet4, nil
+	case *unix.SockaddrInet6:
+		pp := (*unix.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		*pp = unix.RawSockaddrInet6{Family: unix.AF_INET6, Port: htons(sa.Port), Addr: sa.Addr, Scope_id: sa.ZoneId}
+		return unix.SizeofSockaddrInet6, nil
+	default:
+		return 0, unix.EAFNOSUPPORT
+	}
+}
+
+// ntohs sockaddr 中的端口是网络字节序（大端）
+func ntohs(port uint16) int {
+	p := (*[2]byte)(unsafe.Pointer(&port))
+	return int(p[0])<<8 + int(p[1])
+}
+
+func htons(port int) uint16 {
+	var n uint16
+	p := (*[2]byte)(unsafe.Pointer(&n))
+	p[0], p[1] = byte(port>>8), byte(port)
+	return n
+}
+
+// errnoErr 与 x/sys/unix 一致，常见的 errno 返回预先分配的错误，方便使用 == 比较
+func errnoErr(e syscall.Errno) error {
+	switch e {
+	case unix.EAGAIN:
+		return unix.EAGAIN
+	case unix.EINVAL:
+		return unix.EINVAL
+	case unix.ENOENT:
+		return unix.ENOENT
+	}
+	return e
+}
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+)
+
+// unixSocketListen 创建 unix domain socket（SOCK_STREAM）监听套接字。
+// 以 @ 开头的地址为 abstract namespace，不会在文件系统中创建文件
+func unixSocketListen(addr string, options *socketOptions) (int, net.Addr, error) {
+	abstract := len(addr) > 0 && addr[0] == '@'
+	if !abstract {
+		if err := removeStaleSocket(addr); err != nil {
+			return -1, nil, err
+		}
+	}
+
+	socketfd, err := unix.Socket(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, nil, err
+	}
+
+	// SockaddrUnix 会将开头的 @ 转换为 \0，即 abstract namespace
+	if err = unix.Bind(socketfd, &unix.SockaddrUnix{Name: addr}); err != nil {
+		_ = unix.Close(socketfd)
+		return -1, nil, err
+	}
+
+	// bind 和 chmod 之间存在窗口，对权限敏感的场景可以通过 umask 或者父目录的权限控制
+	if !abstract && options.socketMode != 0 {
+		if err = os.Chmod(addr, options.socketMode); err != nil {
+			_ = unix.Close(socketfd)
+			_ = os.Remove(addr)
+			return -1, nil, err
+		}
+	}
+
+	if err = unix.Listen(socketfd, unix.SOMAXCONN); err != nil {
+		_ = unix.Close(socketfd)
+		if !abstract {
+			_ = os.Remove(addr)
+		}
+		return -1, nil, err
+	}
+	return socketfd, &net.UnixAddr{Name: addr, Net: "unix"}, nil
+}
+
+// removeStaleSocket 清理上次进程异常退出残留的 socket 文件。
+// 只删除没有进程在监听的 socket 文件，其他类型的文件或者仍在使用的 socket 返回 EADDRINUSE
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return unix.EADDRINUSE
+	}
+
+	// 尝试连接，连接被拒绝说明没有进程在监听
+	fd, err := unix.Socket(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	switch err := unix.Connect(fd, &unix.SockaddrUnix{Name: path}); err {
+	case nil:
+		return unix.EADDRINUSE
+	case unix.ECONNREFUSED:
+		return os.Remove(path)
+	default:
+		return err
+	}
+}
+
+// RemoveUnixSocket 关闭监听后删除 socket 文件，abstract namespace 地址不需要删除
+func RemoveUnixSocket(addr string) error {
+	if len(addr) == 0 || addr[0] == '@' {
+		return nil
+	}
+	if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
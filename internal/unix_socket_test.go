@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestUnixSocketListen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jinx.sock")
+
+	lnfd, addr, err := SocketListen("unix", path, WithSocketMode(0600))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != path {
+		t.Fatalf("unexpected addr %v", addr)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("unexpected socket mode %v", fi.Mode())
+	}
+
+	// 仍然在监听的 socket 文件不能被覆盖
+	if _, _, err := SocketListen("unix", path); err != unix.EADDRINUSE {
+		t.Fatalf("expected EADDRINUSE, got %v", err)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+
+	// 模拟进程异常退出，socket 文件残留
+	_ = unix.Close(lnfd)
+	lnfd, _, err = SocketListen("unix", path)
+	if err != nil {
+		t.Fatalf("stale socket not removed, %v", err)
+	}
+	_ = unix.Close(lnfd)
+	if err := RemoveUnixSocket(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("socket file not removed")
+	}
+
+	// 普通文件不能被删除
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := SocketListen("unix", path); err != unix.EADDRINUSE {
+		t.Fatalf("expected EADDRINUSE, got %v", err)
+	}
+}
+
+func TestUnixSocketListenAbstract(t *testing.T) {
+	name := "@jinx-test-" + strconv.Itoa(os.Getpid())
+	lnfd, _, err := SocketListen("unix", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(lnfd)
+
+	conn, err := net.Dial("unix", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	connfd, _, err := unix.Accept(lnfd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(connfd)
+
+	cred, err := unix.GetsockoptUcred(connfd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(cred.Pid) != os.Getpid() {
+		t.Fatalf("unexpected peer pid %d", cred.Pid)
+	}
+}
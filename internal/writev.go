@@ -0,0 +1,14 @@
+package internal
+
+import "golang.org/x/sys/unix"
+
+// IOVMax 一次 writev 最多提交的 iovec 个数，超过内核的 UIO_MAXIOV(1024) 会返回 EINVAL
+const IOVMax = 1024
+
+// Writev 将 bufs 中的数据按顺序写入 fd，超过 IOVMax 的部分不会写入，由调用方根据返回的长度继续写
+func Writev(fd int, bufs [][]byte) (int, error) {
+	if len(bufs) > IOVMax {
+		bufs = bufs[:IOVMax]
+	}
+	return unix.Writev(fd, bufs)
+}
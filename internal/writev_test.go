@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"bytes"
+	"golang.org/x/sys/unix"
+	"testing"
+)
+
+func TestWritev(t *testing.T) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+
+	// 超过 IOVMax 的缓冲区只写入前 IOVMax 个
+	bufs := make([][]byte, IOVMax+10)
+	var want []byte
+	for i := range bufs {
+		bufs[i] = []byte{byte(i)}
+		if i < IOVMax {
+			want = append(want, byte(i))
+		}
+	}
+	n, err := Writev(fds[0], bufs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != IOVMax {
+		t.Fatalf("expected %d bytes written, got %d", IOVMax, n)
+	}
+
+	got := make([]byte, IOVMax*2)
+	n, err = unix.Read(fds[1], got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got[:n], want) {
+		t.Fatal("data mismatch")
+	}
+}
+
+func TestLinkedBufferPeekBuffers(t *testing.T) {
+	var lb LinkedBuffer
+	data := make([]byte, maxChunkSize*3)
+	_, _ = lb.Write(data)
+	lb.Discard(10)
+
+	bufs := lb.PeekBuffers(nil, 2)
+	if len(bufs) != 2 || len(bufs[0]) != maxChunkSize-10 || len(bufs[1]) != maxChunkSize {
+		t.Fatalf("unexpected buffers: %d", len(bufs))
+	}
+	bufs = lb.PeekBuffers(bufs[:0], IOVMax)
+	var size int
+	for _, b := range bufs {
+		size += len(b)
+	}
+	if len(bufs) != 3 || size != lb.Len() {
+		t.Fatalf("expected 3 buffers with %d bytes, got %d with %d bytes", lb.Len(), len(bufs), size)
+	}
+}
@@ -1,35 +1,55 @@
 package jinx
 
 import (
+	"context"
 	"fmt"
+	"github.com/imlgw/jinx/errors"
+	"github.com/imlgw/jinx/internal"
 	"log"
 	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Server interface {
 	handler
 	Run() error
+
+	// Stop 优雅关闭，等待 outBuffer flush 的时间由 WithShutdownTimeout 决定
 	Stop() error
+
+	// Shutdown 优雅关闭：停止 accept，等待所有连接的 outBuffer flush 完成后关闭连接并退出 eventloop。
+	// ctx 结束时强制关闭剩余连接并返回 ctx.Err()
+	Shutdown(ctx context.Context) error
+
 	ServerName() string
 	Network() string
 	ServerAddr() string
 	Started() bool
+
+	// Submit 投递任务到序号为 loopIdx 的 eventloop 中执行，可以在任意 goroutine 中调用
+	Submit(loopIdx int, f func()) error
+
+	// AfterFunc d 之后在某个 eventloop 中执行 f，可以在任意 goroutine 中调用
+	AfterFunc(d time.Duration, f func()) (*Timer, error)
+
+	// Every 每隔 d 在同一个 eventloop 中执行一次 f，直到调用 Timer.Stop
+	Every(d time.Duration, f func()) (*Timer, error)
 }
 
 type server struct {
-	network    string
-	addr       string
-	opts       *Options
-	ln         *listener
-	started    bool
-	wg         sync.WaitGroup
-	loopGroup  *eventLoopGroup
+	network   string
+	addr      string
+	opts      *Options
+	ln        *listener
+	started   int32 // 原子操作，其他 goroutine 通过 Started 读取
+	wg        sync.WaitGroup
+	once      sync.Once     // 保证只关闭一次
+	done      chan struct{} // 所有 eventloop 退出后关闭
+	loopGroup *eventLoopGroup
+	eventHandler
 	onBoot     func(s Server)
-	onOpen     func(c Conn)
-	onClose    func(c Conn)
-	onRead     func(c Conn)
-	onWrite    func(c Conn)
 	onShutdown func(s Server)
 }
 
@@ -48,86 +68,191 @@ func NewServer(network, addr string, opts ...Option) (Server, error) {
 	s.opts = options
 	s.network = network
 	s.addr = addr
-	// 初始化 loopGroup
-	s.loopGroup = newEventGroup(s.opts.Lb)
+	s.done = make(chan struct{})
+	// 初始化 loopGroup，并创建 loopNum 个事件循环
+	s.loopGroup = newEventGroup(s.opts.Lb, s.opts.Balancer)
+	for i := 0; i < s.opts.LoopNum; i++ {
+		loop, err := newLoop(i, s.opts, &s.eventHandler)
+		if err != nil {
+			return nil, err
+		}
+		loop.ser = s
+		s.loopGroup.register(loop)
+	}
+
+	if isUDP(network) || s.opts.ReusePort {
+		// udp 没有连接，每个 loop 创建一个 SO_REUSEPORT 的套接字，由内核将数据报分发到各个 loop。
+		// tcp 开启 ReusePort 时同样每个 loop 各自 accept，不再经过 mainReactor 转交连接
+		if network == "unix" {
+			return nil, errors.ErrUnsupportedOp
+		}
+		if err := s.listenPerLoop(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
 
 	// 创建 listener
-	listener, err := newListener(s.network, s.addr, s)
+	mainLoop, err := newLoop(-1, s.opts, &s.eventHandler)
 	if err != nil {
 		return nil, err
 	}
+	mainLoop.ser = s
+	listener, err := newListener(s.network, s.addr, mainLoop)
+	if err != nil {
+		_ = mainLoop.Close()
+		return nil, err
+	}
 	s.ln = listener
 	fmt.Println("lnfd", s.ln.lnfd)
 	return s, nil
 }
 
-func (s *server) Run() error {
-	// 启动 listener
-	s.wg.Add(1)
-	go func() {
-		if err := s.ln.run(); err != nil {
-			log.Printf("listener loop run error,  %v\n", err)
+// listenPerLoop 每个 loop 绑定同一个地址的 SO_REUSEPORT 套接字
+func (s *server) listenPerLoop() error {
+	addr := s.addr
+	firstfd := -1
+	for _, loop := range s.loopGroup.loops {
+		ln, err := newListener(s.network, addr, loop, internal.WithReusePort(true))
+		if err != nil {
+			_ = s.loopGroup.stopAll()
+			return err
+		}
+		// 端口为 0 时由内核分配，后续的套接字绑定同一个端口
+		addr = ln.addr.String()
+		if firstfd < 0 {
+			firstfd = ln.lnfd
 		}
-		s.wg.Done()
-	}()
+	}
 
-	// 创建并启动 loopNum 个事件循环
-	for i := 0; i < s.opts.LoopNum; i++ {
-		loop, err := newLoop(i, s)
-		if err != nil {
+	if s.opts.ReusePortCPUSteering {
+		// 按照 bind 的顺序，第 i 个套接字对应第 i 个 loop，BPF 程序根据 CPU 选择套接字
+		if err := internal.AttachReusePortCPUSteering(firstfd, len(s.loopGroup.loops)); err != nil {
+			_ = s.loopGroup.stopAll()
 			return err
 		}
-		s.loopGroup.register(loop)
+	}
+	return nil
+}
+
+func (s *server) Run() error {
+	// 启动 loopNum 个事件循环
+	for _, loop := range s.loopGroup.loops {
+		loop := loop
 		s.wg.Add(1)
 		go func() {
-			// 服务启动 latch
 			if err := loop.poll(); err != nil {
-				log.Printf("create and run loop error, %v \n", err)
+				log.Printf("run loop error, %v \n", err)
+			}
+			// 退出 loop 后关闭剩余的连接以及 epoll
+			if err := loop.Close(); err != nil {
+				log.Printf("close loop error, %v \n", err)
+			}
+			s.wg.Done()
+		}()
+	}
+
+	// 启动 listener，事件循环先于 listener 启动，避免 accept 的连接找不到 subReactor
+	if s.ln != nil {
+		s.wg.Add(1)
+		go func() {
+			if err := s.ln.run(); err != nil {
+				log.Printf("listener loop run error,  %v\n", err)
+			}
+			// 关闭 listener 以及 mainLoop
+			if err := s.ln.loop.Close(); err != nil {
+				log.Printf("close listener error, %v \n", err)
 			}
 			s.wg.Done()
-			// // 服务关闭 latch，避免并发修改 map 异常
-			// s.loopGroup.wg.Done()
 		}()
 	}
-	s.started = true
+	atomic.StoreInt32(&s.started, 1)
 
 	if s.onBoot != nil {
 		s.onBoot(s)
 	}
 	s.wg.Wait()
+	close(s.done)
 	return nil
 }
 
+func (s *server) Submit(loopIdx int, f func()) error {
+	if loopIdx < 0 || loopIdx >= len(s.loopGroup.loops) {
+		return errors.ErrInvalidLoopIndex
+	}
+	return s.loopGroup.loops[loopIdx].epoll.Trigger(func(interface{}) error {
+		f()
+		return nil
+	}, nil)
+}
+
 func (s *server) Stop() error {
-	if s.onShutdown != nil {
-		s.onShutdown(s)
+	ctx := context.Background()
+	if s.opts.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.opts.ShutdownTimeout)
+		defer cancel()
 	}
+	return s.Shutdown(ctx)
+}
+
+func (s *server) Shutdown(ctx context.Context) error {
+	var err error
+	s.once.Do(func() {
+		err = s.shutdown(ctx)
+		if s.onShutdown != nil {
+			s.onShutdown(s)
+		}
+	})
+	return err
+}
 
-	// TODO: 唤醒所有 eventloop 退出 loop
+func (s *server) shutdown(ctx context.Context) error {
+	// 还没有启动，直接释放资源
+	if !s.Started() {
+		if err := s.loopGroup.stopAll(); err != nil {
+			return err
+		}
+		if s.ln != nil {
+			return s.ln.loop.Close()
+		}
+		return nil
+	}
 
-	// 等待所有 loop
-	// s.loopGroup.wg.Wait()
+	// 停止 accept，唤醒 mainReactor 退出 loop
+	if s.ln != nil {
+		if err := s.ln.stop(); err != nil {
+			log.Printf("stop listener error, %v \n", err)
+		}
+	}
 
-	// 关闭所有 connection 以及 subReactor 的 eventloop
-	if err := s.loopGroup.stopAll(); err != nil {
-		return err
+	// 唤醒所有 subReactor，等待 outBuffer flush 完成后关闭连接并退出 loop
+	for _, loop := range s.loopGroup.loops {
+		if err := loop.epoll.Trigger(loop.drain, nil); err != nil {
+			log.Printf("trigger drain error, %v \n", err)
+		}
 	}
 
-	// 关闭 listener 以及 mainReactor 的 eventloop
-	if err := s.ln.Close(); err != nil {
-		return err
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
 	}
 
-	return nil
+	// 超时，强制关闭剩余连接
+	for _, loop := range s.loopGroup.loops {
+		// 已经退出的 loop 会返回 ErrEventLoopClosed，忽略即可
+		if err := loop.epoll.Trigger(loop.forceClose, nil); err != nil && err != errors.ErrEventLoopClosed {
+			log.Printf("trigger force close error, %v \n", err)
+		}
+	}
+	<-s.done
+	return ctx.Err()
 }
 
 func (s *server) ServerName() string          { return s.opts.ServerName }
 func (s *server) Network() string             { return s.network }
 func (s *server) ServerAddr() string          { return s.addr }
-func (s *server) Started() bool               { return s.started }
+func (s *server) Started() bool               { return atomic.LoadInt32(&s.started) == 1 }
 func (s *server) OnBoot(f func(s Server))     { s.onBoot = f }
-func (s *server) OnOpen(f func(c Conn))       { s.onOpen = f }
-func (s *server) OnClose(f func(c Conn))      { s.onClose = f }
-func (s *server) OnRead(f func(c Conn))       { s.onRead = f }
-func (s *server) OnWrite(f func(c Conn))      { s.onWrite = f }
 func (s *server) OnShutdown(f func(s Server)) { s.onShutdown = f }
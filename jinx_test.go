@@ -1,17 +1,24 @@
 package jinx
 
 import (
+	"context"
+	"github.com/imlgw/jinx/internal/testutil"
+	"golang.org/x/sys/unix"
 	"log"
 	"net"
+	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var wg sync.WaitGroup
 
 func TestSimpleJinxServer(t *testing.T) {
 	network := "tcp"
-	addr := ":9876"
+	addr := ":9877"
 
 	server, err := NewServer(network, addr, WithLb(RoundRobin), WithLoopNum(4), WithServerName("Resolmi"))
 	if err != nil {
@@ -59,20 +66,20 @@ func TestSimpleJinxServer(t *testing.T) {
 			s.ServerName(), s.Network(), s.ServerAddr())
 	})
 
-	if err := server.Run(); err != nil {
-		t.Fatal(err)
-	}
-
+	done := testutil.StartServer(t, server, 3*time.Second)
 	wg.Add(1)
-	for !server.Started() {
-	}
 	go startClient(network, addr)
 	wg.Wait()
-	wg.Add(1)
 	if err := server.Stop(); err != nil {
 		t.Fatal(err)
 	}
-	wg.Wait()
+
+	// Stop 之后 Run 需要返回
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Run not returned after Stop")
+	}
 }
 
 func startClient(network string, addr string) {
@@ -96,3 +103,236 @@ func startClient(network string, addr string) {
 	}
 	wg.Done()
 }
+
+func TestServerShutdownTimeout(t *testing.T) {
+	addr := "127.0.0.1:9882"
+	server, err := NewServer("tcp", addr, WithLoopNum(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened := make(chan struct{})
+	server.OnOpen(func(c Conn) {
+		// 客户端不读取，数据堆积在 outBuffer 中
+		_, _ = c.Write(make([]byte, 32<<20))
+		close(opened)
+	})
+	var shutdown int32
+	server.OnShutdown(func(s Server) {
+		atomic.StoreInt32(&shutdown, 1)
+	})
+
+	done := testutil.StartServer(t, server, 3*time.Second)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-opened
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if atomic.LoadInt32(&shutdown) != 1 {
+		t.Fatal("OnShutdown not called")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Run not returned after Shutdown")
+	}
+
+	// 剩余的连接已经被强制关闭
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 64<<10)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Fatal("conn not closed after shutdown timeout")
+			}
+			break
+		}
+	}
+}
+
+func TestServerShutdownFlush(t *testing.T) {
+	addr := "127.0.0.1:9913"
+	server, err := NewServer("tcp", addr, WithLoopNum(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const size = 8 << 20
+	opened := make(chan struct{})
+	server.OnOpen(func(c Conn) {
+		// 超过内核缓冲区大小，关闭时还有数据留在 outBuffer 中
+		_, _ = c.Write(make([]byte, size))
+		close(opened)
+	})
+
+	done := testutil.StartServer(t, server, 3*time.Second)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-opened
+
+	// 客户端慢慢读取，直到服务端 flush 完成后关闭连接
+	received := make(chan int, 1)
+	go func() {
+		var n int
+		buf := make([]byte, 64<<10)
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			time.Sleep(time.Millisecond)
+			m, err := conn.Read(buf)
+			n += m
+			if err != nil {
+				received <- n
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown error, %v", err)
+	}
+	if n := <-received; n != size {
+		t.Fatalf("expected %d bytes flushed before shutdown, got %d", size, n)
+	}
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Run not returned after Shutdown")
+	}
+}
+
+func TestServerIPv6(t *testing.T) {
+	srv, err := NewServer("tcp6", "[::1]:9884", WithLoopNum(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := make(chan [2]net.Addr, 1)
+	srv.OnOpen(func(c Conn) {
+		addrs <- [2]net.Addr{c.LocalAddr(), c.RemoteAddr()}
+	})
+	testutil.StartServer(t, srv, 3*time.Second)
+
+	conn, err := net.Dial("tcp6", "[::1]:9884")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case a := <-addrs:
+		if a[0].String() != conn.RemoteAddr().String() || a[1].String() != conn.LocalAddr().String() {
+			t.Fatalf("unexpected addr local %v remote %v, expected local %v remote %v",
+				a[0], a[1], conn.RemoteAddr(), conn.LocalAddr())
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("conn not opened")
+	}
+}
+
+func TestServerUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jinx.sock")
+	srv, err := NewServer("unix", path, WithLoopNum(1), WithSocketMode(0660))
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := make(chan *unix.Ucred, 1)
+	srv.OnOpen(func(c Conn) {
+		cred, err := c.PeerCred()
+		if err != nil {
+			t.Error(err)
+		}
+		creds <- cred
+	})
+	done := testutil.StartServer(t, srv, 3*time.Second)
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case cred := <-creds:
+		if cred == nil || int(cred.Pid) != os.Getpid() || int(cred.Uid) != os.Getuid() {
+			t.Fatalf("unexpected peer cred %+v", cred)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("conn not opened")
+	}
+
+	if err := srv.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("socket file not removed after stop")
+	}
+}
+
+func TestServerReusePort(t *testing.T) {
+	for _, steering := range []bool{false, true} {
+		addr := "127.0.0.1:9888"
+		srv, err := NewServer("tcp", addr, WithLoopNum(4), WithReusePort(true), WithReusePortCPUSteering(steering))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := srv.(*server)
+		if s.ln != nil {
+			t.Fatal("reuse port mode shouldn't create main listener")
+		}
+		srv.OnRead(func(c Conn) {
+			buf := make([]byte, 64)
+			n, _ := c.Read(buf)
+			_, _ = c.Write(buf[:n])
+		})
+		done := testutil.StartServer(t, srv, 3*time.Second)
+
+		const clients = 16
+		conns := make([]net.Conn, clients)
+		for i := range conns {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conns[i] = conn
+			_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+			if _, err := conn.Write([]byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 64)
+			if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "hello" {
+				t.Fatalf("unexpected response %q, %v", buf[:n], err)
+			}
+		}
+
+		var total uint64
+		for _, loop := range s.loopGroup.loops {
+			total += atomic.LoadUint64(&loop.conncnt)
+		}
+		if total != clients {
+			t.Fatalf("expected %d connections, got %d", clients, total)
+		}
+
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+		if err := srv.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		<-done
+	}
+}
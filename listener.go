@@ -1,44 +1,49 @@
 package jinx
 
 import (
+	"github.com/imlgw/jinx/errors"
 	"github.com/imlgw/jinx/internal"
 	"golang.org/x/sys/unix"
 	"log"
 	"net"
 	"sync"
-	"sync/atomic"
 )
 
 type listener struct {
-	once sync.Once
-	addr net.Addr
-	lnfd int
-	loop *eventloop
+	once    sync.Once
+	network string
+	addr    net.Addr
+	lnfd    int
+	family  int // 协议族，udp WriteTo 时转换地址使用
+	loop    *eventloop
 }
 
-func newListener(network, addr string, ser *server) (*listener, error) {
+func newListener(network, addr string, loop *eventloop, opts ...internal.SocketOption) (*listener, error) {
 	// 生成一个 Listener（主要是拿 listenerfd 加入 eventloop）
 	// listen, err := net.Listen(network, addr)
 	// 这里不使用 net.Listen，这个会将 fd 直接加入 netpoll 的 eventloop，不确定会不会有其他影响
-	socketfd, naddr, err := internal.SocketListen(network, addr)
+	opts = append(opts,
+		internal.WithIPv6Only(loop.opts.IPv6Only), internal.WithSocketMode(loop.opts.SocketMode))
+	socketfd, naddr, err := internal.SocketListen(network, addr, opts...)
 	if err != nil {
 		return nil, err
 	}
-	mainLoop, err := newLoop(-1, ser)
+	family, err := internal.SocketFamily(socketfd)
 	if err != nil {
+		_ = unix.Close(socketfd)
 		return nil, err
 	}
 
-	// 将 socketfd 加入 mainLoop 的 epoll 事件中监听可读事件。
-	// 发生读事件说明有连接进入（监听套接字的可读事件就是tcp全连接队列非空）
+	// 将 socketfd 加入 loop 的 epoll 事件中监听可读事件。
+	// 发生读事件说明有连接进入（监听套接字的可读事件就是tcp全连接队列非空），udp 则是有数据报到达
 	// https://zhuanlan.zhihu.com/p/399651675
-	if err := mainLoop.epoll.RegRead(socketfd); err != nil {
+	if err := loop.epoll.RegRead(socketfd); err != nil {
+		_ = unix.Close(socketfd)
 		return nil, err
 	}
-	l := &listener{lnfd: socketfd, loop: mainLoop, addr: &net.TCPAddr{IP: naddr.IP, Port: naddr.Port}}
+	l := &listener{network: network, lnfd: socketfd, family: family, loop: loop, addr: naddr}
 	// 绑定到 loop 的响应器上
-	mainLoop.reactor[socketfd] = l
-	atomic.AddUint64(&mainLoop.conncnt, 1)
+	loop.reactor[socketfd] = l
 	return l, nil
 }
 
@@ -50,24 +55,39 @@ func (l *listener) run() error {
 	return nil
 }
 
+// stop 唤醒 mainLoop 退出事件循环，不再 accept 新连接
+func (l *listener) stop() error {
+	return l.loop.epoll.Trigger(func(interface{}) error {
+		return errors.ErrServerShutdown
+	}, nil)
+}
+
+// Close 关闭监听套接字，所在的 loop 由调用方关闭
 func (l *listener) Close() error {
 	l.once.Do(
 		func() {
-			// 关闭 listener 同时关闭 mainLoop
-			if err := l.loop.Close(); err != nil {
-				log.Printf("close error %v \n", err)
-				return
-			}
+			delete(l.loop.reactor, l.lnfd)
 			if err := unix.Close(l.lnfd); err != nil {
 				log.Printf("close lnfd error %v \n", err)
 				return
 			}
-			l.loop = nil
-			l.addr = nil
+			// unix domain socket 需要删除 socket 文件，否则下次启动时 bind 失败
+			if ua, ok := l.addr.(*net.UnixAddr); ok {
+				if err := internal.RemoveUnixSocket(ua.Name); err != nil {
+					log.Printf("remove unix socket error %v \n", err)
+				}
+			}
 		})
 	return nil
 }
 
 func (l *listener) handleEvent(fd int, _ internal.EventType) error {
+	if isUDP(l.network) {
+		return l.loop.handleDatagram(l)
+	}
 	return l.loop.handleAccept(fd)
 }
+
+func isUDP(network string) bool {
+	return network == "udp" || network == "udp4" || network == "udp6"
+}
@@ -1,8 +1,12 @@
 package jinx
 
 import (
+	"hash/fnv"
 	"math/rand"
 	"net"
+	"sort"
+	"strconv"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,8 +22,54 @@ const (
 
 	// Random 根据服务器列表的大小来随机获取其中的一台来访问，随着调用量的增大，实际效果越来越近似于平均分配到没一台服务器，和轮询的效果类似
 	Random
+
+	// SourceAddrHash 根据客户端 IP 的哈希值取模选择 loop，同一个客户端 IP 的连接总是分配到同一个 loop，
+	// 可以在 loop 内部无锁的保存客户端相关的状态（限流，会话等）
+	SourceAddrHash
+
+	// ConsistentHash 一致性哈希，同样保证同一个客户端 IP 分配到同一个 loop，loop 数量变化时只有少量客户端需要迁移
+	ConsistentHash
 )
 
+// LoopStats eventloop 的只读统计信息，供自定义负载均衡使用
+type LoopStats interface {
+	// Index loop 序号
+	Index() int
+	// ConnCount 当前连接数
+	ConnCount() int
+	// PendingBytes outBuffer 中等待写入内核的字节数
+	PendingBytes() int
+	// QueuedTasks 任务队列中等待执行的任务数
+	QueuedTasks() int
+}
+
+// Balancer 自定义负载均衡，通过 WithBalancer 设置，优先级高于 WithLb
+type Balancer interface {
+	// Next 为新连接选择 loop，返回 loop 在 loops 中的下标。server 在 mainReactor 的 goroutine 中调用，
+	// client 在调用 AsyncDial 的 goroutine 中调用，调用之间是串行的，不需要考虑并发
+	Next(loops []LoopStats, addr net.Addr) int
+}
+
+// customBalancer 将 Balancer 适配为内部的负载均衡
+type customBalancer struct {
+	b     Balancer
+	stats []LoopStats
+}
+
+func (c *customBalancer) next(loops []*eventloop, addr net.Addr) (e *eventloop) {
+	if len(c.stats) != len(loops) {
+		c.stats = make([]LoopStats, len(loops))
+		for i, loop := range loops {
+			c.stats[i] = loop
+		}
+	}
+	idx := c.b.Next(c.stats, addr)
+	if idx < 0 || idx >= len(loops) {
+		idx = 0
+	}
+	return loops[idx]
+}
+
 // RoundRobin
 type roundRobin struct {
 	idx int
@@ -36,6 +86,14 @@ type leastConnections struct {
 }
 
 func (l *leastConnections) next(loops []*eventloop, addr net.Addr) (e *eventloop) {
+	// conncnt 在 subReactor 关闭连接时修改，这里需要原子读取
+	e = loops[0]
+	least := atomic.LoadUint64(&e.conncnt)
+	for _, loop := range loops[1:] {
+		if cnt := atomic.LoadUint64(&loop.conncnt); cnt < least {
+			e, least = loop, cnt
+		}
+	}
 	return
 }
 
@@ -48,3 +106,82 @@ func (r *random) next(loops []*eventloop, addr net.Addr) (e *eventloop) {
 	rand.Seed(time.Now().UnixNano())
 	return loops[rand.Intn(len(loops))]
 }
+
+// SourceAddrHash
+type sourceAddrHash struct {
+}
+
+func (h *sourceAddrHash) next(loops []*eventloop, addr net.Addr) (e *eventloop) {
+	return loops[hashAddr(addr)%uint32(len(loops))]
+}
+
+// ConsistentHash
+type consistentHash struct {
+	// 每个 loop 在哈希环上的虚拟节点数
+	replicas int
+	// 哈希环，按照 hash 值有序
+	ring []uint32
+	// 虚拟节点 hash 值对应的 loop
+	nodes map[uint32]*eventloop
+	// 构建哈希环时 loop 的数量，数量变化时重新构建
+	size int
+}
+
+const defaultReplicas = 160
+
+func (h *consistentHash) next(loops []*eventloop, addr net.Addr) (e *eventloop) {
+	if h.size != len(loops) {
+		h.build(loops)
+	}
+	key := hashAddr(addr)
+	// 顺时针找到第一个大于等于 key 的虚拟节点
+	i := sort.Search(len(h.ring), func(i int) bool { return h.ring[i] >= key })
+	if i == len(h.ring) {
+		i = 0
+	}
+	return h.nodes[h.ring[i]]
+}
+
+func (h *consistentHash) build(loops []*eventloop) {
+	if h.replicas <= 0 {
+		h.replicas = defaultReplicas
+	}
+	h.ring = make([]uint32, 0, len(loops)*h.replicas)
+	h.nodes = make(map[uint32]*eventloop, len(loops)*h.replicas)
+	for _, loop := range loops {
+		for i := 0; i < h.replicas; i++ {
+			key := hashString(strconv.Itoa(loop.idx) + "#" + strconv.Itoa(i))
+			if _, ok := h.nodes[key]; ok {
+				continue
+			}
+			h.nodes[key] = loop
+			h.ring = append(h.ring, key)
+		}
+	}
+	sort.Slice(h.ring, func(i, j int) bool { return h.ring[i] < h.ring[j] })
+	h.size = len(loops)
+}
+
+// hashAddr 计算客户端地址的哈希值，只使用 IP，忽略端口
+func hashAddr(addr net.Addr) uint32 {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return hashBytes(addr.IP.To16())
+	case *net.UDPAddr:
+		return hashBytes(addr.IP.To16())
+	case nil:
+		return 0
+	default:
+		return hashString(addr.String())
+	}
+}
+
+func hashBytes(b []byte) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write(b)
+	return h.Sum32()
+}
+
+func hashString(s string) uint32 {
+	return hashBytes([]byte(s))
+}
@@ -0,0 +1,241 @@
+package jinx
+
+import (
+	"github.com/imlgw/jinx/internal/testutil"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestLoops(n int) []*eventloop {
+	loops := make([]*eventloop, n)
+	for i := range loops {
+		loops[i] = &eventloop{idx: i}
+	}
+	return loops
+}
+
+func TestLeastConnections(t *testing.T) {
+	loops := newTestLoops(4)
+	lb := &leastConnections{}
+
+	atomic.StoreUint64(&loops[0].conncnt, 3)
+	atomic.StoreUint64(&loops[1].conncnt, 1)
+	atomic.StoreUint64(&loops[2].conncnt, 2)
+	atomic.StoreUint64(&loops[3].conncnt, 1)
+	// 连接数相同时选择序号最小的
+	if e := lb.next(loops, nil); e.idx != 1 {
+		t.Fatalf("expected loop 1, got %d", e.idx)
+	}
+}
+
+func TestLeastConnectionsUnevenLifetime(t *testing.T) {
+	loops := newTestLoops(4)
+	lb := &leastConnections{}
+	// 固定随机种子，测试结果可以复现
+	r := rand.New(rand.NewSource(1))
+
+	type conn struct {
+		loop *eventloop
+		ttl  int
+	}
+	var alive []conn
+	for round := 0; round < 10000; round++ {
+		// 模拟连接关闭，连接的存活时间长短不一
+		for i := 0; i < len(alive); {
+			if alive[i].ttl--; alive[i].ttl <= 0 {
+				atomic.AddUint64(&alive[i].loop.conncnt, ^uint64(0))
+				alive[i] = alive[len(alive)-1]
+				alive = alive[:len(alive)-1]
+				continue
+			}
+			i++
+		}
+
+		e := lb.next(loops, nil)
+		for _, loop := range loops {
+			if atomic.LoadUint64(&loop.conncnt) < atomic.LoadUint64(&e.conncnt) {
+				t.Fatalf("loop %d has less connections than selected loop %d", loop.idx, e.idx)
+			}
+		}
+		atomic.AddUint64(&e.conncnt, 1)
+		ttl := 1 + r.Intn(5)
+		if r.Intn(10) == 0 {
+			ttl = 200 + r.Intn(200)
+		}
+		alive = append(alive, conn{loop: e, ttl: ttl})
+	}
+
+	// 任意时刻各个 loop 的连接数相差不超过 1
+	var min, max uint64 = 1<<64 - 1, 0
+	for _, loop := range loops {
+		cnt := atomic.LoadUint64(&loop.conncnt)
+		if cnt < min {
+			min = cnt
+		}
+		if cnt > max {
+			max = cnt
+		}
+	}
+	if max-min > 1 {
+		t.Fatalf("unbalanced connections, min %d, max %d", min, max)
+	}
+}
+
+func TestLeastConnectionsServer(t *testing.T) {
+	addr := "127.0.0.1:9883"
+	srv, err := NewServer("tcp", addr, WithLb(LeastConnections), WithLoopNum(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened := make(chan Conn, 4)
+	srv.OnOpen(func(c Conn) { opened <- c })
+	closed := make(chan struct{}, 4)
+	srv.OnClose(func(c Conn) { closed <- struct{}{} })
+
+	testutil.StartServer(t, srv, 3*time.Second)
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-opened
+		return conn
+	}
+
+	// 第一个连接关闭后，下一个连接应该分配到空闲的 loop
+	c1, c2 := dial(), dial()
+	defer c2.Close()
+	s := srv.(*server)
+	if cnt0, cnt1 := atomic.LoadUint64(&s.loopGroup.loops[0].conncnt), atomic.LoadUint64(&s.loopGroup.loops[1].conncnt); cnt0 != 1 || cnt1 != 1 {
+		t.Fatalf("expected 1 connection per loop, got %d, %d", cnt0, cnt1)
+	}
+	_ = c1.Close()
+	<-closed
+	c3 := dial()
+	defer c3.Close()
+	if cnt0, cnt1 := atomic.LoadUint64(&s.loopGroup.loops[0].conncnt), atomic.LoadUint64(&s.loopGroup.loops[1].conncnt); cnt0 != 1 || cnt1 != 1 {
+		t.Fatalf("expected 1 connection per loop, got %d, %d", cnt0, cnt1)
+	}
+}
+
+func TestSourceAddrHash(t *testing.T) {
+	loops := newTestLoops(4)
+	lb := &sourceAddrHash{}
+
+	// 同一个 IP 的不同端口分配到同一个 loop
+	ip := net.ParseIP("10.0.0.1")
+	first := lb.next(loops, &net.TCPAddr{IP: ip, Port: 10000})
+	for port := 10001; port < 10100; port++ {
+		if e := lb.next(loops, &net.TCPAddr{IP: ip, Port: port}); e != first {
+			t.Fatalf("same ip dispatched to loop %d and %d", first.idx, e.idx)
+		}
+	}
+
+	// 不同 IP 尽量均匀分布
+	cnt := make(map[int]int)
+	for i := 0; i < 4000; i++ {
+		ip := net.IPv4(10, byte(i>>16), byte(i>>8), byte(i))
+		cnt[lb.next(loops, &net.TCPAddr{IP: ip, Port: 80}).idx]++
+	}
+	for idx, n := range cnt {
+		if n < 500 {
+			t.Fatalf("loop %d only got %d clients", idx, n)
+		}
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	lb := &consistentHash{}
+	loops := newTestLoops(4)
+
+	ip := net.ParseIP("2001:db8::1")
+	first := lb.next(loops, &net.TCPAddr{IP: ip, Port: 10000})
+	if e := lb.next(loops, &net.TCPAddr{IP: ip, Port: 20000}); e != first {
+		t.Fatalf("same ip dispatched to loop %d and %d", first.idx, e.idx)
+	}
+
+	const clients = 10000
+	before := make([]int, clients)
+	cnt := make(map[int]int)
+	for i := 0; i < clients; i++ {
+		ip := net.IPv4(10, byte(i>>16), byte(i>>8), byte(i))
+		before[i] = lb.next(loops, &net.TCPAddr{IP: ip}).idx
+		cnt[before[i]]++
+	}
+	for idx, n := range cnt {
+		if n < clients/4/2 {
+			t.Fatalf("loop %d only got %d clients", idx, n)
+		}
+	}
+
+	// 增加一个 loop，只有分配到新 loop 的客户端发生迁移
+	loops = append(loops, &eventloop{idx: 4})
+	moved := 0
+	for i := 0; i < clients; i++ {
+		ip := net.IPv4(10, byte(i>>16), byte(i>>8), byte(i))
+		idx := lb.next(loops, &net.TCPAddr{IP: ip}).idx
+		if idx != before[i] {
+			if idx != 4 {
+				t.Fatalf("client %d moved from loop %d to old loop %d", i, before[i], idx)
+			}
+			moved++
+		}
+	}
+	if moved > clients/5*2 {
+		t.Fatalf("too many clients moved, %d", moved)
+	}
+}
+
+// leastPending 选择待写字节数最少的 loop
+type leastPending struct {
+	calls int
+}
+
+func (l *leastPending) Next(loops []LoopStats, addr net.Addr) int {
+	l.calls++
+	idx := 0
+	for i, loop := range loops {
+		if loop.PendingBytes() < loops[idx].PendingBytes() {
+			idx = i
+		}
+	}
+	return idx
+}
+
+func TestCustomBalancer(t *testing.T) {
+	b := &leastPending{}
+	g := newEventGroup(RoundRobin, b)
+	for i := 0; i < 3; i++ {
+		loop, err := newLoop(i, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer loop.epoll.Close()
+		g.register(loop)
+	}
+
+	g.loops[0].addPending(100)
+	g.loops[1].addPending(10)
+	g.loops[2].addPending(50)
+	if e := g.next(nil); e.idx != 1 {
+		t.Fatalf("expected loop 1, got %d", e.idx)
+	}
+	g.loops[1].addPending(100)
+	if e := g.next(nil); e.idx != 2 {
+		t.Fatalf("expected loop 2, got %d", e.idx)
+	}
+	if b.calls != 2 {
+		t.Fatalf("expected balancer called 2 times, got %d", b.calls)
+	}
+
+	stats := LoopStats(g.loops[2])
+	_ = g.loops[2].epoll.Trigger(func(interface{}) error { return nil }, nil)
+	if stats.Index() != 2 || stats.ConnCount() != 0 || stats.PendingBytes() != 50 || stats.QueuedTasks() != 1 {
+		t.Fatalf("unexpected loop stats, idx %d, conns %d, pending %d, tasks %d",
+			stats.Index(), stats.ConnCount(), stats.PendingBytes(), stats.QueuedTasks())
+	}
+}
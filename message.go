@@ -0,0 +1,93 @@
+package jinx
+
+import (
+	"bytes"
+	"github.com/imlgw/jinx/codec"
+	"github.com/imlgw/jinx/errors"
+	"io"
+	"log"
+)
+
+// frameReader 将 inBuffer 中已经收到的数据包装为 net.Conn 交给 ICodec.Decode，Decode 只能读到这部分数据，
+// 数据不足时返回 io.EOF/io.ErrUnexpectedEOF 而不会阻塞 eventloop
+type frameReader struct {
+	Conn
+	r *bytes.Reader
+}
+
+func (fr *frameReader) Read(b []byte) (int, error) { return fr.r.Read(b) }
+
+// inboundBuffer 将连接的 inBuffer 适配为 codec.Buffer
+type inboundBuffer struct{ *connection }
+
+func (b inboundBuffer) Len() int { return b.inBuffer.Len() }
+
+func (b inboundBuffer) Scanned() int     { return b.scanned }
+func (b inboundBuffer) SetScanned(n int) { b.scanned = n }
+
+// decodeMessages 使用 Codec 从 inBuffer 中解码出所有完整的帧并回调 OnMessage，不完整的帧保留在 inBuffer 中等待更多数据。
+// Codec 实现了 codec.FrameCodec 时直接对 inBuffer 增量解码，否则通过 frameReader 适配 ICodec.Decode
+func (loop *eventloop) decodeMessages(c *connection) error {
+	if fc, ok := c.codec.(codec.FrameCodec); ok {
+		return loop.decodeFrames(c, fc)
+	}
+	for !c.closed && c.inBuffer.Len() > 0 {
+		data, _ := c.Peek(0)
+		fr := &frameReader{Conn: c, r: bytes.NewReader(data)}
+		msg, err := c.codec.Decode(fr)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			log.Printf("decode message error, %v \n", err)
+			return c.Close()
+		}
+		c.inBuffer.Discard(len(data) - fr.r.Len())
+		loop.handler.onMessage(c, msg)
+	}
+	return nil
+}
+
+func (loop *eventloop) decodeFrames(c *connection, fc codec.FrameCodec) error {
+	buf := inboundBuffer{c}
+	for !c.closed && c.inBuffer.Len() > 0 {
+		// 正在丢弃超长的帧，丢弃完成之后继续解码
+		if c.discarding > 0 {
+			c.discarding -= c.inBuffer.Discard(c.discarding)
+			continue
+		}
+		msg, consumed, err := fc.DecodeFrame(buf)
+		if err != nil {
+			if err == errors.ErrIncomplete {
+				return nil
+			}
+			if err == errors.ErrTooLongFrame && consumed > 0 {
+				log.Printf("discard too long frame, %d bytes \n", consumed)
+				c.discarding = consumed
+				continue
+			}
+			log.Printf("decode message error, %v \n", err)
+			return c.Close()
+		}
+		c.inBuffer.Discard(consumed)
+		loop.handler.onMessage(c, msg)
+	}
+	return nil
+}
+
+func (c *connection) Send(msg []byte) error {
+	if c.pipeline.head != nil {
+		return c.pipeline.Write(msg)
+	}
+	if c.codec != nil {
+		encoded, err := c.codec.Encode(msg)
+		if err != nil {
+			return err
+		}
+		msg = encoded
+	}
+	_, err := c.Write(msg)
+	return err
+}
+
+func (c *connection) Pipeline() *Pipeline { return c.pipeline }
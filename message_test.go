@@ -0,0 +1,182 @@
+package jinx
+
+import (
+	"encoding/binary"
+	"github.com/imlgw/jinx/codec"
+	"github.com/imlgw/jinx/internal/testutil"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOnMessage(t *testing.T) {
+	addr := "127.0.0.1:9900"
+	lc := codec.NewDefaultLengthFieldCodec()
+	srv, err := NewServer("tcp", addr, WithLoopNum(1), WithCodec(lc), WithInboundBuffer(16, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reads int
+	srv.OnRead(func(c Conn) { reads++ })
+	srv.OnMessage(func(c Conn, msg []byte) {
+		if err := c.Send(append([]byte("echo:"), msg...)); err != nil {
+			t.Error(err)
+		}
+	})
+	testutil.StartServer(t, srv, 3*time.Second)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 一次写入多个帧，以及被拆分到两次写入的帧
+	var data []byte
+	for i := 0; i < 20; i++ {
+		encoded, _ := lc.Encode([]byte("imlgw.top"))
+		data = append(data, encoded...)
+	}
+	split, _ := lc.Encode([]byte("split frame"))
+	data = append(data, split[:5]...)
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := conn.Write(split[5:]); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for i := 0; i < 21; i++ {
+		msg, err := lc.Decode(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "echo:imlgw.top"
+		if i == 20 {
+			want = "echo:split frame"
+		}
+		if string(msg) != want {
+			t.Fatalf("unexpected message %q", msg)
+		}
+	}
+
+	// 设置 OnMessage 之后不再回调 OnRead
+	done := make(chan int)
+	_ = srv.Submit(0, func() { done <- reads })
+	if n := <-done; n != 0 {
+		t.Fatalf("OnRead should not be called, got %d", n)
+	}
+}
+
+// blockingCodec 只实现 ICodec，通过 frameReader 适配阻塞的 Decode
+type blockingCodec struct{ codec.ICodec }
+
+func TestOnMessageBlockingCodec(t *testing.T) {
+	addr := "127.0.0.1:9901"
+	lc := codec.NewDefaultLengthFieldCodec()
+	srv, err := NewServer("tcp", addr, WithLoopNum(1), WithCodec(blockingCodec{lc}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.OnMessage(func(c Conn, msg []byte) { _ = c.Send(msg) })
+	testutil.StartServer(t, srv, 3*time.Second)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	encoded, _ := lc.Encode([]byte("imlgw.top"))
+	if _, err := conn.Write(encoded[:3]); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := conn.Write(append(encoded[3:], encoded...)); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for i := 0; i < 2; i++ {
+		msg, err := lc.Decode(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg) != "imlgw.top" {
+			t.Fatalf("unexpected message %q", msg)
+		}
+	}
+}
+
+func TestOnMessageTooLongFrame(t *testing.T) {
+	for _, failFast := range []bool{false, true} {
+		addr := "127.0.0.1:9902"
+		if failFast {
+			addr = "127.0.0.1:9903"
+		}
+		lc := codec.NewLengthFieldCodec(binary.BigEndian, 0, 4, 0, 4, false, codec.WithMaxFrameLength(64, failFast))
+		srv, err := NewServer("tcp", addr, WithLoopNum(1), WithCodec(lc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv.OnMessage(func(c Conn, msg []byte) { _ = c.Send(msg) })
+		testutil.StartServer(t, srv, 3*time.Second)
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		long, _ := lc.Encode(make([]byte, 1<<20))
+		short, _ := lc.Encode([]byte("imlgw.top"))
+		// 超长帧写入的同时服务端可能已经关闭连接，忽略写入错误
+		_, _ = conn.Write(append(long, short...))
+
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		msg, err := lc.Decode(conn)
+		if failFast {
+			// failFast 直接关闭连接
+			if err == nil {
+				t.Fatalf("expected conn closed, got %q", msg)
+			}
+		} else if err != nil || string(msg) != "imlgw.top" {
+			t.Fatalf("expected frame after discarding too long frame, got %q, %v", msg, err)
+		}
+		_ = conn.Close()
+		_ = srv.Stop()
+	}
+}
+
+func TestOnMessageLineCodec(t *testing.T) {
+	addr := "127.0.0.1:9904"
+	srv, err := NewServer("tcp", addr, WithLoopNum(1), WithCodec(codec.NewLineBasedFrameCodec(64, true, false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.OnMessage(func(c Conn, msg []byte) { _ = c.Send(append([]byte("+"), msg...)) })
+	testutil.StartServer(t, srv, 3*time.Second)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("PING\r\nEC")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := conn.Write([]byte("HO\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "+PING\n+ECHO\n"
+	got := make([]byte, len(want))
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected response %q", got)
+	}
+}
@@ -2,6 +2,8 @@ package jinx
 
 import (
 	"github.com/imlgw/jinx/codec"
+	"os"
+	"time"
 )
 
 // Option is a function that will set up option.
@@ -23,11 +25,44 @@ type Options struct {
 	// both server & client options
 	Codec codec.ICodec
 
+	// 每个连接的处理阶段，入站数据按顺序经过每个阶段之后回调 OnMessage，Send 的数据按相反的顺序经过每个阶段之后写入连接。
+	// 不为空时代替 Codec，设置之后不再回调 OnRead
+	Pipeline []Handler
+
 	// 负载均衡配置
 	Lb LoadBalance
 
+	// 自定义负载均衡，不为 nil 时忽略 Lb
+	Balancer Balancer
+
 	// subReactor 对应的 eventloop 数量
 	LoopNum int
+
+	// 连接 inBuffer 的最小以及最大容量，inBuffer 按需扩容，数据被消费完之后缩容到最小容量。
+	// 最大容量 <= 0 表示不限制，超过最大容量时关闭连接
+	InboundBufferMin int
+	InboundBufferMax int
+
+	// 是否只监听 IPv6，为 false 时 "tcp" 监听通配地址同时接收 IPv4 和 IPv6 连接，"tcp6" 总是只接收 IPv6
+	IPv6Only bool
+
+	// unix domain socket 文件的权限，为 0 时由 umask 决定
+	SocketMode os.FileMode
+
+	// 每个 loop 创建自己的 SO_REUSEPORT 监听套接字并各自 accept，由内核分发连接，不再经过 mainReactor
+	ReusePort bool
+
+	// ReusePort 模式下挂载 CBPF 程序，按照处理软中断的 CPU 将连接分发到对应的 loop
+	ReusePortCPUSteering bool
+
+	// udp 批量读写的数据报个数，> 1 时使用 recvmmsg/sendmmsg
+	UDPBatchSize int
+
+	// 连接空闲（没有读写数据）超过该时间后回调 OnTimeout 并关闭连接，<= 0 表示不限制
+	IdleTimeout time.Duration
+
+	// Stop 时等待 outBuffer 中的数据 flush 的最长时间，超时后强制关闭剩余连接，<= 0 表示一直等待
+	ShutdownTimeout time.Duration
 }
 
 func WithServerName(name string) Option {
@@ -42,14 +77,75 @@ func WithCodec(codec codec.ICodec) Option {
 	}
 }
 
+func WithPipeline(handlers ...Handler) Option {
+	return func(opts *Options) {
+		opts.Pipeline = handlers
+	}
+}
+
 func WithLb(lb LoadBalance) Option {
 	return func(opts *Options) {
 		opts.Lb = lb
 	}
 }
 
+func WithBalancer(b Balancer) Option {
+	return func(opts *Options) {
+		opts.Balancer = b
+	}
+}
+
 func WithLoopNum(loopNum int) Option {
 	return func(opts *Options) {
 		opts.LoopNum = loopNum
 	}
 }
+
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.ShutdownTimeout = timeout
+	}
+}
+
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.IdleTimeout = timeout
+	}
+}
+
+func WithIPv6Only(ipv6Only bool) Option {
+	return func(opts *Options) {
+		opts.IPv6Only = ipv6Only
+	}
+}
+
+func WithSocketMode(mode os.FileMode) Option {
+	return func(opts *Options) {
+		opts.SocketMode = mode
+	}
+}
+
+func WithUDPBatch(size int) Option {
+	return func(opts *Options) {
+		opts.UDPBatchSize = size
+	}
+}
+
+func WithReusePort(reusePort bool) Option {
+	return func(opts *Options) {
+		opts.ReusePort = reusePort
+	}
+}
+
+func WithReusePortCPUSteering(steering bool) Option {
+	return func(opts *Options) {
+		opts.ReusePortCPUSteering = steering
+	}
+}
+
+func WithInboundBuffer(min, max int) Option {
+	return func(opts *Options) {
+		opts.InboundBufferMin = min
+		opts.InboundBufferMax = max
+	}
+}
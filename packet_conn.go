@@ -0,0 +1,163 @@
+package jinx
+
+import (
+	"github.com/imlgw/jinx/internal"
+	"golang.org/x/sys/unix"
+	"log"
+	"net"
+	"sync"
+)
+
+// PacketConn udp 套接字，OnDatagram 中通过 WriteTo 回复数据报
+type PacketConn interface {
+	// WriteTo 发送数据报到 addr，addr 需要是 *net.UDPAddr，可以在任意 goroutine 中调用。
+	// 开启 WithUDPBatch 时 OnDatagram 回调期间写入的数据报先缓存，回调结束后通过 sendmmsg 批量发送，
+	// 其他时候（包括回调之外的 goroutine）与未开启时一样直接调用 sendto
+	WriteTo(b []byte, addr net.Addr) (int, error)
+
+	// LocalAddr 本端地址
+	LocalAddr() net.Addr
+}
+
+// maxDatagramsPerEvent 单次可读事件最多处理的数据报数量，避免一个繁忙的套接字饿死其他事件
+const maxDatagramsPerEvent = 64
+
+// WriteTo 直接调用 sendto，可以在任意 goroutine 中调用
+func (l *listener) WriteTo(b []byte, addr net.Addr) (int, error) {
+	sa, err := l.sockaddr(addr)
+	if err != nil {
+		return 0, err
+	}
+	if err := unix.Sendto(l.lnfd, b, 0, sa); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (l *listener) LocalAddr() net.Addr { return l.addr }
+
+func (l *listener) sockaddr(addr net.Addr) (unix.Sockaddr, error) {
+	ua, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return nil, unix.EAFNOSUPPORT
+	}
+	return internal.UDPAddrToSockaddr(l.family, ua)
+}
+
+// handleDatagram udp 可读事件处理，读取数据报并回调 OnDatagram，data 只在回调期间有效
+func (loop *eventloop) handleDatagram(l *listener) error {
+	if loop.opts.UDPBatchSize > 1 {
+		return loop.handleDatagramBatch(l)
+	}
+	for i := 0; i < maxDatagramsPerEvent; i++ {
+		n, sa, err := unix.Recvfrom(l.lnfd, loop.buffer, 0)
+		if err != nil {
+			if err == unix.EAGAIN || err == unix.EINTR {
+				return nil
+			}
+			return err
+		}
+		if loop.handler.onDatagram != nil {
+			loop.handler.onDatagram(l, loop.buffer[:n], internal.SockaddrToUDPAddr(sa))
+		}
+	}
+	return nil
+}
+
+// handleDatagramBatch 通过 recvmmsg 批量读取数据报，回调中的 WriteTo 先缓存起来，回调结束后通过 sendmmsg 批量发送
+func (loop *eventloop) handleDatagramBatch(l *listener) error {
+	if loop.batch == nil {
+		loop.batch = newPacketBatch(l, loop.opts.UDPBatchSize)
+	}
+	b := loop.batch
+	for i := 0; i < maxDatagramsPerEvent; i += len(b.in.Bufs) {
+		n, err := b.in.Recvmmsg(l.lnfd)
+		if err != nil {
+			if err == unix.EAGAIN || err == unix.EINTR {
+				break
+			}
+			return err
+		}
+		b.setDispatching(true)
+		for j := 0; j < n; j++ {
+			if loop.handler.onDatagram != nil {
+				loop.handler.onDatagram(b, b.in.Bufs[j][:b.in.Lens[j]], internal.RawSockaddrToUDPAddr(&b.in.Names[j]))
+			}
+		}
+		b.setDispatching(false)
+		if n < len(b.in.Bufs) {
+			break
+		}
+	}
+	return nil
+}
+
+// packetBatch 批量模式下传给 OnDatagram 的 PacketConn，回调期间 WriteTo 只是缓存数据，回调之外直接发送
+type packetBatch struct {
+	l   *listener
+	in  *internal.MsgBatch
+	out *internal.MsgBatch
+
+	// mu 保护以下字段，回调中保存的 PacketConn 可能在其他 goroutine 中调用 WriteTo
+	mu          sync.Mutex
+	dispatching bool // 正在回调 OnDatagram
+	bufs        [][]byte
+	addrs       []unix.Sockaddr
+}
+
+func newPacketBatch(l *listener, size int) *packetBatch {
+	return &packetBatch{
+		l:   l,
+		in:  internal.NewMsgBatch(size, 0xffff),
+		out: internal.NewMsgBatch(size, 0),
+	}
+}
+
+func (b *packetBatch) WriteTo(p []byte, addr net.Addr) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.dispatching {
+		return b.l.WriteTo(p, addr)
+	}
+	sa, err := b.l.sockaddr(addr)
+	if err != nil {
+		return 0, err
+	}
+	if len(b.bufs) == len(b.out.Bufs) {
+		b.flush()
+	}
+	// 回调结束后 p 可能被复用，需要拷贝
+	b.bufs = append(b.bufs, append([]byte(nil), p...))
+	b.addrs = append(b.addrs, sa)
+	return len(p), nil
+}
+
+func (b *packetBatch) LocalAddr() net.Addr { return b.l.addr }
+
+// setDispatching 标记回调开始或者结束，结束时发送回调中缓存的数据报
+func (b *packetBatch) setDispatching(dispatching bool) {
+	b.mu.Lock()
+	b.dispatching = dispatching
+	if !dispatching {
+		b.flush()
+	}
+	b.mu.Unlock()
+}
+
+// flush 通过 sendmmsg 发送缓存的数据报，发送缓冲区满时丢弃剩余数据报（udp 本身不保证送达），调用方需要持有 mu
+func (b *packetBatch) flush() {
+	for sent := 0; sent < len(b.bufs); {
+		n, err := b.out.Sendmmsg(b.l.lnfd, b.bufs[sent:], b.addrs[sent:])
+		if err != nil {
+			if err != unix.EAGAIN {
+				log.Printf("sendmmsg error, %v \n", err)
+			}
+			break
+		}
+		sent += n
+	}
+	for i := range b.bufs {
+		b.bufs[i], b.addrs[i] = nil, nil
+	}
+	b.bufs, b.addrs = b.bufs[:0], b.addrs[:0]
+}
@@ -0,0 +1,132 @@
+package jinx
+
+import (
+	"github.com/imlgw/jinx/internal/testutil"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testUDPEcho(t *testing.T, addr string, opts ...Option) {
+	srv, err := NewServer("udp", addr, append(opts, WithLoopNum(2))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.OnDatagram(func(pc PacketConn, data []byte, from net.Addr) {
+		if _, err := pc.WriteTo(append([]byte("echo:"), data...), from); err != nil {
+			t.Error(err)
+		}
+	})
+	done := testutil.StartServer(t, srv, 3*time.Second)
+
+	// 多个客户端，源端口不同，由内核分发到不同的 loop
+	for c := 0; c < 4; c++ {
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		buf := make([]byte, 128)
+		for i := 0; i < 20; i++ {
+			msg := "msg-" + strconv.Itoa(c) + "-" + strconv.Itoa(i)
+			if _, err := conn.Write([]byte(msg)); err != nil {
+				t.Fatal(err)
+			}
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(buf[:n]) != "echo:"+msg {
+				t.Fatalf("unexpected response %q", buf[:n])
+			}
+		}
+		_ = conn.Close()
+	}
+
+	// 突发多个数据报，批量模式下一次 recvmmsg 读取多个
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	const burst = 50
+	for i := 0; i < burst; i++ {
+		if _, err := conn.Write([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	got := make(map[string]bool)
+	buf := make([]byte, 128)
+	for i := 0; i < burst; i++ {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[string(buf[:n])] = true
+	}
+	for i := 0; i < burst; i++ {
+		if !got["echo:"+strconv.Itoa(i)] {
+			t.Fatalf("missing response for %d", i)
+		}
+	}
+
+	if err := srv.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Run not returned after Stop")
+	}
+}
+
+func TestUDPServer(t *testing.T) {
+	testUDPEcho(t, "127.0.0.1:9885")
+}
+
+func TestUDPServerBatch(t *testing.T) {
+	testUDPEcho(t, "127.0.0.1:9886", WithUDPBatch(8))
+}
+
+func TestUDPServerIPv6(t *testing.T) {
+	testUDPEcho(t, "[::1]:9887", WithUDPBatch(8))
+}
+
+func TestUDPServerBatchWriteOutsideCallback(t *testing.T) {
+	addr := "127.0.0.1:9916"
+	srv, err := NewServer("udp", addr, WithLoopNum(1), WithUDPBatch(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.OnDatagram(func(pc PacketConn, data []byte, from net.Addr) {
+		// 回调结束之后在其他 goroutine 中回复，不需要等到下一次可读事件
+		msg := append([]byte("late:"), data...)
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			if _, err := pc.WriteTo(msg, from); err != nil {
+				t.Error(err)
+			}
+		}()
+	})
+	testutil.StartServer(t, srv, 3*time.Second)
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "late:ping" {
+		t.Fatalf("unexpected response %q", buf[:n])
+	}
+}
@@ -0,0 +1,287 @@
+package jinx
+
+import (
+	"github.com/imlgw/jinx/codec"
+	"github.com/imlgw/jinx/errors"
+	"log"
+)
+
+// Handler pipeline 中的一个阶段，参考 netty 的 ChannelInboundHandler 以及 ChannelOutboundHandler。
+// 入站数据按照添加的顺序依次经过每个阶段（例如 解密 -> 分帧 -> 解压 -> 应用解码），出站数据按照相反的顺序经过每个阶段。
+// 同一个 Handler 被所有连接共享，连接相关的状态保存在 HandlerContext.State 中。
+// Pipeline 通过 == 查找 Handler，Handler 需要是可比较的类型（一般为指针）
+type Handler interface {
+	// HandleRead 处理入站数据，通过 ctx.FireRead 交给下一个阶段，可以调用多次（一次收到多个帧）也可以不调用（数据不足一个帧）
+	HandleRead(ctx *HandlerContext, msg []byte) error
+
+	// HandleWrite 处理出站数据，通过 ctx.FireWrite 交给前一个阶段，第一个阶段 FireWrite 的数据写入连接
+	HandleWrite(ctx *HandlerContext, msg []byte) error
+}
+
+// HandlerContext 连接上某个阶段的上下文，每个连接的每个阶段各自一个
+type HandlerContext struct {
+	pipeline   *Pipeline
+	handler    Handler
+	prev, next *HandlerContext
+	removed    bool
+
+	// State 阶段在当前连接上的状态，例如分帧时累积的数据、压缩的字典，由 Handler 自行创建和管理
+	State interface{}
+}
+
+func (ctx *HandlerContext) Conn() Conn                 { return ctx.pipeline.c }
+func (ctx *HandlerContext) Handler() Handler           { return ctx.handler }
+func (ctx *HandlerContext) Pipeline() *Pipeline        { return ctx.pipeline }
+func (ctx *HandlerContext) Removed() bool              { return ctx.removed }
+func (ctx *HandlerContext) FireRead(msg []byte) error  { return ctx.pipeline.fireRead(ctx.next, msg) }
+func (ctx *HandlerContext) FireWrite(msg []byte) error { return ctx.pipeline.fireWrite(ctx.prev, msg) }
+
+// Pipeline 连接的处理阶段链表，只能在连接所属的 eventloop 中使用和修改。
+// 在 HandleRead 中修改 pipeline 是安全的：被移除的阶段仍然可以通过 FireRead/FireWrite 把数据交给原来的前后阶段，
+// 被替换的阶段 FireRead 的数据交给替换它的阶段，例如握手完成之后移除握手阶段、替换分帧方式
+type Pipeline struct {
+	c          *connection
+	head, tail *HandlerContext
+}
+
+func newPipeline(c *connection, handlers []Handler) *Pipeline {
+	p := &Pipeline{c: c}
+	for _, h := range handlers {
+		p.AddLast(h)
+	}
+	return p
+}
+
+// Len 阶段数量，为 0 时连接退回到 Codec/OnRead 的处理方式
+func (p *Pipeline) Len() int {
+	n := 0
+	for ctx := p.head; ctx != nil; ctx = ctx.next {
+		n++
+	}
+	return n
+}
+
+// Handlers 按照入站顺序返回所有阶段
+func (p *Pipeline) Handlers() []Handler {
+	var hs []Handler
+	for ctx := p.head; ctx != nil; ctx = ctx.next {
+		hs = append(hs, ctx.handler)
+	}
+	return hs
+}
+
+// Context 返回 h 在当前连接上的上下文，h 不在 pipeline 中时返回 nil
+func (p *Pipeline) Context(h Handler) *HandlerContext {
+	for ctx := p.head; ctx != nil; ctx = ctx.next {
+		if ctx.handler == h {
+			return ctx
+		}
+	}
+	return nil
+}
+
+// AddFirst 添加 h 作为第一个阶段（最先处理入站数据，最后处理出站数据）
+func (p *Pipeline) AddFirst(h Handler) *HandlerContext {
+	return p.insert(nil, p.head, h)
+}
+
+// AddLast 添加 h 作为最后一个阶段（最后处理入站数据，最先处理出站数据）
+func (p *Pipeline) AddLast(h Handler) *HandlerContext {
+	return p.insert(p.tail, nil, h)
+}
+
+// AddBefore 在 base 之前添加 h，base 不在 pipeline 中时返回 nil
+func (p *Pipeline) AddBefore(base, h Handler) *HandlerContext {
+	ctx := p.Context(base)
+	if ctx == nil {
+		return nil
+	}
+	return p.insert(ctx.prev, ctx, h)
+}
+
+// AddAfter 在 base 之后添加 h，base 不在 pipeline 中时返回 nil
+func (p *Pipeline) AddAfter(base, h Handler) *HandlerContext {
+	ctx := p.Context(base)
+	if ctx == nil {
+		return nil
+	}
+	return p.insert(ctx, ctx.next, h)
+}
+
+// Remove 移除 h，h 不在 pipeline 中时返回 false
+func (p *Pipeline) Remove(h Handler) bool {
+	ctx := p.Context(h)
+	if ctx == nil {
+		return false
+	}
+	// 保留 ctx 自身的 prev/next，正在执行的 HandleRead/HandleWrite 仍然可以继续传递数据
+	ctx.removed = true
+	if ctx.prev == nil {
+		p.head = ctx.next
+	} else {
+		ctx.prev.next = ctx.next
+	}
+	if ctx.next == nil {
+		p.tail = ctx.prev
+	} else {
+		ctx.next.prev = ctx.prev
+	}
+	return true
+}
+
+// Replace 使用 h 替换 old，old 不在 pipeline 中时返回 nil
+func (p *Pipeline) Replace(old, h Handler) *HandlerContext {
+	ctx := p.Context(old)
+	if ctx == nil {
+		return nil
+	}
+	p.Remove(old)
+	nctx := p.insert(ctx.prev, ctx.next, h)
+	// old 之后 FireRead 的数据交给 h 处理，例如分帧阶段被替换时把剩余的数据交给新的分帧阶段；
+	// FireWrite 的数据已经由 old 处理过，仍然交给原来的前一个阶段，例如 HTTP 升级到 WebSocket 时的 101 响应
+	ctx.next = nctx
+	return nctx
+}
+
+func (p *Pipeline) insert(prev, next *HandlerContext, h Handler) *HandlerContext {
+	ctx := &HandlerContext{pipeline: p, handler: h, prev: prev, next: next}
+	if prev == nil {
+		p.head = ctx
+	} else {
+		prev.next = ctx
+	}
+	if next == nil {
+		p.tail = ctx
+	} else {
+		next.prev = ctx
+	}
+	return ctx
+}
+
+// FireRead 从第一个阶段开始处理入站数据
+func (p *Pipeline) FireRead(msg []byte) error { return p.fireRead(p.head, msg) }
+
+// Write 从最后一个阶段开始处理出站数据，经过所有阶段之后写入连接
+func (p *Pipeline) Write(msg []byte) error { return p.fireWrite(p.tail, msg) }
+
+// fireRead 交给 ctx 处理，ctx 为 nil 说明已经经过了所有阶段，回调 OnMessage
+func (p *Pipeline) fireRead(ctx *HandlerContext, msg []byte) error {
+	if p.c.closed {
+		return errors.ErrConnClosed
+	}
+	if ctx != nil {
+		return ctx.handler.HandleRead(ctx, msg)
+	}
+	if onMessage := p.c.loop.handler.onMessage; onMessage != nil {
+		onMessage(p.c, msg)
+	}
+	return nil
+}
+
+// fireWrite 交给 ctx 处理，ctx 为 nil 说明已经经过了所有阶段，写入连接
+func (p *Pipeline) fireWrite(ctx *HandlerContext, msg []byte) error {
+	if ctx != nil {
+		return ctx.handler.HandleWrite(ctx, msg)
+	}
+	_, err := p.c.Write(msg)
+	return err
+}
+
+// firePipeline 将 inBuffer 中的数据全部交给 pipeline，处理出错时关闭连接
+func (loop *eventloop) firePipeline(c *connection) error {
+	head, tail := c.inBuffer.Peek(0)
+	// 拷贝一份，各个阶段可以保留 msg，inBuffer 可以立即复用
+	data := make([]byte, 0, len(head)+len(tail))
+	data = append(append(data, head...), tail...)
+	c.inBuffer.Discard(len(data))
+	if err := c.pipeline.FireRead(data); err != nil && !c.closed {
+		log.Printf("pipeline handle read error, %v \n", err)
+		return c.Close()
+	}
+	return nil
+}
+
+// frameHandler 使用 FrameCodec 分帧的阶段，入站时累积数据并解码出完整的帧，出站时编码
+type frameHandler struct {
+	fc codec.FrameCodec
+}
+
+// frameState frameHandler 在每个连接上的状态
+type frameState struct {
+	buf        codec.Bytes // 尚未组成完整帧的数据
+	off        int         // buf 中已经解码的长度
+	discarding int         // 丢弃超长帧时剩余需要丢弃的字节数
+	scanned    int         // 分隔符解码已经扫描过的长度，见 codec.ScanBuffer
+}
+
+// frameState 作为 codec.ScanBuffer 交给 FrameCodec 解码 buf[off:]
+func (st *frameState) Len() int                   { return len(st.buf) - st.off }
+func (st *frameState) Peek(n int) ([]byte, error) { return st.buf[st.off:].Peek(n) }
+func (st *frameState) Scanned() int               { return st.scanned }
+func (st *frameState) SetScanned(n int)           { st.scanned = n }
+
+// NewFrameHandler 将 FrameCodec 包装为 pipeline 中的分帧阶段
+func NewFrameHandler(fc codec.FrameCodec) Handler {
+	return &frameHandler{fc: fc}
+}
+
+func (fh *frameHandler) HandleRead(ctx *HandlerContext, msg []byte) error {
+	st, ok := ctx.State.(*frameState)
+	if !ok {
+		st = new(frameState)
+		ctx.State = st
+	}
+	if st.discarding > 0 {
+		n := st.discarding
+		if n > len(msg) {
+			n = len(msg)
+		}
+		st.discarding -= n
+		msg = msg[n:]
+	}
+	st.buf = append(st.buf, msg...)
+
+	for st.off < len(st.buf) {
+		frame, consumed, err := fh.fc.DecodeFrame(st)
+		if err != nil {
+			if err == errors.ErrIncomplete {
+				break
+			}
+			if err == errors.ErrTooLongFrame && consumed > 0 {
+				log.Printf("discard too long frame, %d bytes \n", consumed)
+				if remain := st.Len(); consumed > remain {
+					st.discarding = consumed - remain
+					consumed = remain
+				}
+				st.off += consumed
+				continue
+			}
+			return err
+		}
+		st.off += consumed
+		if err := ctx.FireRead(frame); err != nil {
+			return err
+		}
+		// 在后续阶段中被移除或者替换（例如握手完成后升级协议），剩余的数据交给后面的阶段
+		if ctx.removed {
+			rest := st.buf[st.off:]
+			st.buf, st.off = nil, 0
+			if len(rest) == 0 {
+				return nil
+			}
+			return ctx.FireRead(rest)
+		}
+	}
+	// 剩余不完整的数据移动到开头，避免 buf 无限增长
+	n := copy(st.buf, st.buf[st.off:])
+	st.buf, st.off = st.buf[:n], 0
+	return nil
+}
+
+func (fh *frameHandler) HandleWrite(ctx *HandlerContext, msg []byte) error {
+	encoded, err := fh.fc.Encode(msg)
+	if err != nil {
+		return err
+	}
+	return ctx.FireWrite(encoded)
+}
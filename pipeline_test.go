@@ -0,0 +1,177 @@
+package jinx
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/imlgw/jinx/codec"
+	"github.com/imlgw/jinx/internal/testutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// xorHandler 模拟加解密阶段，入站和出站都逐字节异或
+type xorHandler struct{ key byte }
+
+func (h *xorHandler) xor(msg []byte) []byte {
+	out := make([]byte, len(msg))
+	for i, b := range msg {
+		out[i] = b ^ h.key
+	}
+	return out
+}
+
+func (h *xorHandler) HandleRead(ctx *HandlerContext, msg []byte) error {
+	return ctx.FireRead(h.xor(msg))
+}
+
+func (h *xorHandler) HandleWrite(ctx *HandlerContext, msg []byte) error {
+	return ctx.FireWrite(h.xor(msg))
+}
+
+// countHandler 模拟应用解码阶段，在每个连接上各自计数
+type countHandler struct{}
+
+func (h *countHandler) HandleRead(ctx *HandlerContext, msg []byte) error {
+	n, _ := ctx.State.(int)
+	n++
+	ctx.State = n
+	return ctx.FireRead([]byte(fmt.Sprintf("%s#%d", msg, n)))
+}
+
+func (h *countHandler) HandleWrite(ctx *HandlerContext, msg []byte) error {
+	return ctx.FireWrite(append([]byte("out:"), msg...))
+}
+
+// xorConn 客户端解密收到的数据
+type xorConn struct {
+	net.Conn
+	key byte
+}
+
+func (c *xorConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	for i := 0; i < n; i++ {
+		b[i] ^= c.key
+	}
+	return n, err
+}
+
+func TestPipeline(t *testing.T) {
+	addr := "127.0.0.1:9905"
+	lc := codec.NewDefaultLengthFieldCodec()
+	xor := &xorHandler{key: 0x5a}
+	srv, err := NewServer("tcp", addr, WithLoopNum(1), WithPipeline(xor, NewFrameHandler(lc), &countHandler{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.OnMessage(func(c Conn, msg []byte) {
+		if err := c.Send(msg); err != nil {
+			t.Error(err)
+		}
+	})
+	testutil.StartServer(t, srv, 3*time.Second)
+
+	// 每个连接的计数各自独立
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data []byte
+		for _, s := range []string{"a", "b", "split"} {
+			encoded, _ := lc.Encode([]byte(s))
+			data = append(data, xor.xor(encoded)...)
+		}
+		if _, err := conn.Write(data[:len(data)-3]); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if _, err := conn.Write(data[len(data)-3:]); err != nil {
+			t.Fatal(err)
+		}
+
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		xc := &xorConn{Conn: conn, key: xor.key}
+		for _, want := range []string{"out:a#1", "out:b#2", "out:split#3"} {
+			msg, err := lc.Decode(xc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(msg) != want {
+				t.Fatalf("unexpected message %q, want %q", msg, want)
+			}
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestPipelineUpgrade(t *testing.T) {
+	addr := "127.0.0.1:9906"
+	lc := codec.NewDefaultLengthFieldCodec()
+	line := NewFrameHandler(codec.NewLineBasedFrameCodec(1024, true, false))
+	frame := NewFrameHandler(lc)
+	srv, err := NewServer("tcp", addr, WithLoopNum(1), WithPipeline(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.OnMessage(func(c Conn, msg []byte) {
+		p := c.Pipeline()
+		if string(msg) != "HELLO" {
+			_ = c.Send(append([]byte("echo:"), msg...))
+			return
+		}
+		// 握手完成，从按行分帧升级为按长度字段分帧
+		_ = c.Send([]byte("OK"))
+		if p.Replace(line, frame) == nil {
+			t.Error("replace line handler failed")
+		}
+		if p.Remove(line) {
+			t.Error("line handler should have been removed")
+		}
+		if hs := p.Handlers(); len(hs) != 1 || hs[0] != frame {
+			t.Errorf("unexpected handlers %v", hs)
+		}
+	})
+	testutil.StartServer(t, srv, 3*time.Second)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 握手和升级之后的数据在同一次写入中，剩余的数据交给新的分帧阶段
+	encoded, _ := lc.Encode([]byte("x"))
+	if _, err := conn.Write(append([]byte("HELLO\n"), encoded...)); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	r := bufio.NewReader(conn)
+	ok, err := r.ReadString('\n')
+	if err != nil || ok != "OK\n" {
+		t.Fatalf("unexpected handshake response %q, %v", ok, err)
+	}
+	frameConn := &bufferedConn{Conn: conn, r: r}
+	for _, want := range []string{"echo:x", "echo:y"} {
+		msg, err := lc.Decode(frameConn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg) != want {
+			t.Fatalf("unexpected message %q, want %q", msg, want)
+		}
+		encoded, _ := lc.Encode([]byte("y"))
+		if _, err := conn.Write(encoded); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// bufferedConn 先读取 bufio.Reader 中已经缓冲的数据
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) { return c.r.Read(b) }
@@ -0,0 +1,404 @@
+package jinx
+
+import (
+	"github.com/imlgw/jinx/errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// PoolOption 连接池配置
+type PoolOption func(opts *PoolOptions)
+
+// PoolOptions 连接池配置
+type PoolOptions struct {
+	// 每个上游保持的空闲连接数，不足时在定时器中异步补齐
+	MinIdle int
+
+	// 每个上游最多的连接数（空闲 + 使用中 + 正在建立），<= 0 表示不限制
+	MaxConns int
+
+	// 健康检查以及补齐空闲连接的间隔，<= 0 时为 1s
+	HealthCheckInterval time.Duration
+
+	// 应用层健康检查，在连接所属的 eventloop 中对空闲连接执行，返回 error 时关闭连接。
+	// 例如检查最近一次心跳响应的时间，并通过 Write 发送新的心跳
+	HealthCheck func(c Conn) error
+}
+
+func WithPoolMinIdle(n int) PoolOption {
+	return func(opts *PoolOptions) {
+		opts.MinIdle = n
+	}
+}
+
+func WithPoolMaxConns(n int) PoolOption {
+	return func(opts *PoolOptions) {
+		opts.MaxConns = n
+	}
+}
+
+func WithHealthCheck(interval time.Duration, check func(c Conn) error) PoolOption {
+	return func(opts *PoolOptions) {
+		opts.HealthCheckInterval = interval
+		opts.HealthCheck = check
+	}
+}
+
+// PoolStats 单个上游的连接池统计
+type PoolStats struct {
+	Idle     int // 空闲连接数（包括正在健康检查的连接）
+	Active   int // 使用中的连接数（Get 之后还没有 Put）
+	Dialing  int // 正在建立的连接数
+	Dials    uint64
+	DialErrs uint64
+	// Evicted 关闭后被移出连接池的连接数，CheckFailed 健康检查失败的次数
+	Evicted     uint64
+	CheckFailed uint64
+}
+
+type pooledState int
+
+const (
+	pooledIdle pooledState = iota
+	pooledActive
+	pooledChecking // 正在健康检查，检查期间不会被 Get
+)
+
+type pooledConn struct {
+	u     *upstream
+	state pooledState
+}
+
+// upstream 一个上游地址对应的连接
+type upstream struct {
+	network, addr string
+	idle          []Conn
+	counts        [3]int // 每种状态的连接数
+	dialing       int
+	stats         PoolStats
+}
+
+func (u *upstream) total() int {
+	return u.counts[pooledIdle] + u.counts[pooledActive] + u.counts[pooledChecking] + u.dialing
+}
+
+func (u *upstream) setState(pc *pooledConn, state pooledState) {
+	u.counts[pc.state]--
+	pc.state = state
+	u.counts[state]++
+}
+
+// popIdle 取出一个空闲连接
+func (u *upstream) popIdle() Conn {
+	n := len(u.idle)
+	if n == 0 {
+		return nil
+	}
+	c := u.idle[n-1]
+	u.idle[n-1] = nil
+	u.idle = u.idle[:n-1]
+	return c
+}
+
+func (u *upstream) removeIdle(c Conn) {
+	for i, ic := range u.idle {
+		if ic == c {
+			u.idle = append(u.idle[:i], u.idle[i+1:]...)
+			return
+		}
+	}
+}
+
+// Pool client 连接池，按照上游地址保存连接，连接关闭后自动移出连接池。
+// 需要通过 Pool.OnClose 注册关闭回调，直接调用 Client.OnClose 会覆盖连接池的回调
+type Pool struct {
+	Client
+	opts *PoolOptions
+
+	mu        sync.Mutex
+	upstreams map[string]*upstream
+	conns     map[Conn]*pooledConn
+	closed    bool
+	onClose   func(c Conn)
+	ticker    *Timer
+}
+
+// NewPool 创建连接池，cli 需要已经 Start
+func NewPool(cli Client, opts ...PoolOption) (*Pool, error) {
+	options := new(PoolOptions)
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.HealthCheckInterval <= 0 {
+		options.HealthCheckInterval = time.Second
+	}
+	p := &Pool{
+		Client:    cli,
+		opts:      options,
+		upstreams: make(map[string]*upstream),
+		conns:     make(map[Conn]*pooledConn),
+	}
+	cli.OnClose(p.handleClose)
+
+	ticker, err := cli.Every(options.HealthCheckInterval, p.maintain)
+	if err != nil {
+		return nil, err
+	}
+	p.ticker = ticker
+	return p, nil
+}
+
+func (p *Pool) OnClose(f func(c Conn)) {
+	p.mu.Lock()
+	p.onClose = f
+	p.mu.Unlock()
+}
+
+// Get 获取 addr 的一个空闲连接，没有空闲连接时建立新的连接，超过 MaxConns 返回 ErrPoolExhausted。
+// 建立连接时会阻塞，不能在 client 的回调中调用
+func (p *Pool) Get(network, addr string) (Conn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errors.ErrPoolClosed
+	}
+	u := p.upstream(network, addr)
+	if c := u.popIdle(); c != nil {
+		u.setState(p.conns[c], pooledActive)
+		p.mu.Unlock()
+		return c, nil
+	}
+	if p.opts.MaxConns > 0 && u.total() >= p.opts.MaxConns {
+		p.mu.Unlock()
+		return nil, errors.ErrPoolExhausted
+	}
+	u.dialing++
+	p.mu.Unlock()
+
+	c, err := p.Dial(network, addr)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u.dialing--
+	if err != nil {
+		u.stats.DialErrs++
+		return nil, err
+	}
+	u.stats.Dials++
+	if p.closed {
+		closeAsync(c)
+		return nil, errors.ErrPoolClosed
+	}
+	p.add(u, c, pooledActive)
+	return c, nil
+}
+
+// Put 归还 Get 获取的连接，已经关闭的连接直接丢弃
+func (p *Pool) Put(c Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pc, ok := p.conns[c]
+	if !ok || pc.state != pooledActive {
+		return
+	}
+	if p.closed {
+		closeAsync(c)
+		return
+	}
+	pc.u.setState(pc, pooledIdle)
+	pc.u.idle = append(pc.u.idle, c)
+}
+
+// Stats 每个上游的连接池统计，key 为 Get 时的地址
+func (p *Pool) Stats() map[string]PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make(map[string]PoolStats, len(p.upstreams))
+	for addr, u := range p.upstreams {
+		s := u.stats
+		s.Idle = u.counts[pooledIdle] + u.counts[pooledChecking]
+		s.Active = u.counts[pooledActive]
+		s.Dialing = u.dialing
+		stats[addr] = s
+	}
+	return stats
+}
+
+// Warm 异步为 addr 建立 MinIdle 个空闲连接
+func (p *Pool) Warm(network, addr string) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	u := p.upstream(network, addr)
+	n := p.fill(u)
+	p.mu.Unlock()
+	p.dialIdle(u, n)
+}
+
+// Close 停止健康检查并关闭空闲连接，使用中的连接在 Put 时关闭，不会关闭 Client
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	p.ticker.Stop()
+	for _, u := range p.upstreams {
+		for _, c := range u.idle {
+			closeAsync(c)
+		}
+	}
+	return nil
+}
+
+// upstream 获取 addr 对应的上游，调用方需要持有锁
+func (p *Pool) upstream(network, addr string) *upstream {
+	u, ok := p.upstreams[addr]
+	if !ok {
+		u = &upstream{network: network, addr: addr}
+		p.upstreams[addr] = u
+	}
+	return u
+}
+
+// add 将新建立的连接加入连接池，调用方需要持有锁
+func (p *Pool) add(u *upstream, c Conn, state pooledState) {
+	pc := &pooledConn{u: u, state: state}
+	u.counts[state]++
+	p.conns[c] = pc
+	if state == pooledIdle {
+		u.idle = append(u.idle, c)
+	}
+}
+
+// fill 计算补齐 MinIdle 需要建立的连接数并计入 dialing，调用方需要持有锁，释放锁之后通过 dialIdle 建立连接
+func (p *Pool) fill(u *upstream) int {
+	n := p.opts.MinIdle - (u.counts[pooledIdle] + u.counts[pooledChecking] + u.dialing)
+	if p.opts.MaxConns > 0 {
+		if remain := p.opts.MaxConns - u.total(); n > remain {
+			n = remain
+		}
+	}
+	if n <= 0 {
+		return 0
+	}
+	u.dialing += n
+	return n
+}
+
+// dialIdle 在新的 goroutine 中异步建立 n 个空闲连接，不持有锁，也不会阻塞调用方所在的 eventloop
+func (p *Pool) dialIdle(u *upstream, n int) {
+	if n <= 0 {
+		return
+	}
+	go func() {
+		for i := 0; i < n; i++ {
+			if err := p.AsyncDial(u.network, u.addr, func(c Conn, err error) {
+				p.mu.Lock()
+				defer p.mu.Unlock()
+				u.dialing--
+				if err != nil {
+					u.stats.DialErrs++
+					return
+				}
+				u.stats.Dials++
+				if p.closed {
+					// 回调在连接所属的 eventloop 中执行，可以直接关闭
+					_ = c.Close()
+					return
+				}
+				p.add(u, c, pooledIdle)
+			}); err != nil {
+				p.mu.Lock()
+				u.dialing -= n - i
+				u.stats.DialErrs++
+				p.mu.Unlock()
+				log.Printf("pool dial %s error, %v \n", u.addr, err)
+				return
+			}
+		}
+	}()
+}
+
+// maintain 在 eventloop 定时器中执行：对空闲连接做健康检查，并补齐 MinIdle
+func (p *Pool) maintain() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	dials := make(map[*upstream]int)
+	for _, u := range p.upstreams {
+		if p.opts.HealthCheck != nil {
+			for c := u.popIdle(); c != nil; c = u.popIdle() {
+				p.check(p.conns[c], c)
+			}
+		}
+		if n := p.fill(u); n > 0 {
+			dials[u] = n
+		}
+	}
+	p.mu.Unlock()
+
+	for u, n := range dials {
+		p.dialIdle(u, n)
+	}
+}
+
+// check 在连接所属的 eventloop 中执行健康检查，通过后放回空闲列表，调用方需要持有锁
+func (p *Pool) check(pc *pooledConn, c Conn) {
+	pc.u.setState(pc, pooledChecking)
+	if _, err := c.AfterFunc(0, func() {
+		err := p.opts.HealthCheck(c)
+
+		p.mu.Lock()
+		if p.conns[c] != pc {
+			// 检查期间连接已经关闭
+			p.mu.Unlock()
+			return
+		}
+		if err == nil && !p.closed {
+			pc.u.setState(pc, pooledIdle)
+			pc.u.idle = append(pc.u.idle, c)
+			p.mu.Unlock()
+			return
+		}
+		if err != nil {
+			pc.u.stats.CheckFailed++
+			log.Printf("pool health check %s error, %v \n", pc.u.addr, err)
+		}
+		p.mu.Unlock()
+		_ = c.Close()
+	}); err != nil {
+		// 连接已经关闭，handleClose 会将它移出连接池
+		pc.u.setState(pc, pooledIdle)
+		pc.u.idle = append(pc.u.idle, c)
+	}
+}
+
+// handleClose 连接关闭后移出连接池，再回调用户的 OnClose
+func (p *Pool) handleClose(c Conn) {
+	p.mu.Lock()
+	if pc, ok := p.conns[c]; ok {
+		delete(p.conns, c)
+		pc.u.counts[pc.state]--
+		pc.u.stats.Evicted++
+		if pc.state == pooledIdle {
+			pc.u.removeIdle(c)
+		}
+	}
+	onClose := p.onClose
+	p.mu.Unlock()
+	if onClose != nil {
+		onClose(c)
+	}
+}
+
+// closeAsync 在连接所属的 eventloop 中关闭连接
+func closeAsync(c Conn) {
+	_, _ = c.AfterFunc(0, func() { _ = c.Close() })
+}
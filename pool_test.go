@@ -0,0 +1,99 @@
+package jinx
+
+import (
+	"github.com/imlgw/jinx/errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, msg string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPool(t *testing.T) {
+	addr := "127.0.0.1:9899"
+	startEchoServer(t, addr)
+
+	cli, err := NewClient(WithLoopNum(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Stop()
+
+	var unhealthy int32
+	pool, err := NewPool(cli, WithPoolMinIdle(2), WithPoolMaxConns(3),
+		WithHealthCheck(50*time.Millisecond, func(c Conn) error {
+			if atomic.LoadInt32(&unhealthy) == 1 {
+				return errors.ErrConnClosed
+			}
+			return nil
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	var closed int32
+	pool.OnClose(func(c Conn) { atomic.AddInt32(&closed, 1) })
+
+	pool.Warm("tcp", addr)
+	waitFor(t, "pool not warmed", func() bool { return pool.Stats()[addr].Idle == 2 })
+
+	var conns []Conn
+	for i := 0; i < 3; i++ {
+		c, err := pool.Get("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, c)
+	}
+	if _, err := pool.Get("tcp", addr); err != errors.ErrPoolExhausted {
+		t.Fatalf("expected ErrPoolExhausted, got %v", err)
+	}
+	if s := pool.Stats()[addr]; s.Active != 3 || s.Dials != 3 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	for _, c := range conns {
+		pool.Put(c)
+	}
+	if s := pool.Stats()[addr]; s.Active != 0 || s.Idle != 3 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	// 健康检查失败的连接被关闭并移出连接池
+	atomic.StoreInt32(&unhealthy, 1)
+	waitFor(t, "unhealthy conns not evicted", func() bool {
+		s := pool.Stats()[addr]
+		return s.Evicted >= 3 && s.CheckFailed >= 3
+	})
+	if atomic.LoadInt32(&closed) < 3 {
+		t.Fatal("OnClose not called for evicted conns")
+	}
+
+	// 恢复后补齐空闲连接
+	atomic.StoreInt32(&unhealthy, 0)
+	waitFor(t, "idle conns not refilled", func() bool { return pool.Stats()[addr].Idle == 2 })
+
+	c, err := pool.Get("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.IsOpen() {
+		t.Fatal("pool returned closed conn")
+	}
+	pool.Put(c)
+
+	_ = pool.Close()
+	if _, err := pool.Get("tcp", addr); err != errors.ErrPoolClosed {
+		t.Fatalf("expected ErrPoolClosed, got %v", err)
+	}
+}
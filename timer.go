@@ -0,0 +1,122 @@
+package jinx
+
+import (
+	"github.com/imlgw/jinx/errors"
+	"github.com/imlgw/jinx/internal"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	timerActive int32 = iota
+	timerDone         // 已经触发（AfterFunc）或者已经取消
+)
+
+// Timer AfterFunc 以及 Every 返回的定时器，回调在所属 eventloop 的 goroutine 中执行，
+// 可以安全地访问该 loop 上的连接
+type Timer struct {
+	loop     *eventloop
+	t        *internal.Timer // 在 loop 中创建，只在 loop 中访问
+	f        func()
+	interval time.Duration // > 0 表示周期执行
+	state    int32
+	// cond 不为 nil 时每次触发前检查，返回 false 停止定时器，用于连接关闭后不再执行
+	cond func() bool
+}
+
+// Stop 取消定时器，可以在任意 goroutine 中调用。返回 false 表示定时器已经触发（AfterFunc）或者已经取消
+func (t *Timer) Stop() bool {
+	if !atomic.CompareAndSwapInt32(&t.state, timerActive, timerDone) {
+		return false
+	}
+	// loop 已经关闭时定时器也不会再执行，忽略错误
+	_ = t.loop.epoll.Trigger(func(interface{}) error {
+		if t.t != nil {
+			t.loop.epoll.StopTimer(t.t)
+		}
+		return nil
+	}, nil)
+	return true
+}
+
+// start 投递到 loop 中添加定时器
+func (t *Timer) start(d time.Duration) error {
+	when := time.Now().Add(d)
+	return t.loop.epoll.Trigger(func(interface{}) error {
+		if atomic.LoadInt32(&t.state) != timerActive {
+			return nil
+		}
+		if t.cond != nil && !t.cond() {
+			atomic.StoreInt32(&t.state, timerDone)
+			return nil
+		}
+		t.t = t.loop.epoll.AddTimer(when, t.fire)
+		return nil
+	}, nil)
+}
+
+// fire 定时器到期，在 loop 中执行
+func (t *Timer) fire() error {
+	if t.cond != nil && !t.cond() {
+		atomic.StoreInt32(&t.state, timerDone)
+		return nil
+	}
+	if t.interval <= 0 {
+		if atomic.CompareAndSwapInt32(&t.state, timerActive, timerDone) {
+			t.f()
+		}
+		return nil
+	}
+
+	if atomic.LoadInt32(&t.state) != timerActive {
+		return nil
+	}
+	t.f()
+	// 回调中可能调用了 Stop
+	if atomic.LoadInt32(&t.state) != timerActive {
+		return nil
+	}
+	// 固定延迟：回调结束后再间隔 interval 执行下一次，回调执行时间过长时不会累积
+	next := time.Now().Add(t.interval)
+	t.loop.epoll.ResetTimer(t.t, next)
+	return nil
+}
+
+func newTimer(loop *eventloop, d, interval time.Duration, f func()) (*Timer, error) {
+	t := &Timer{loop: loop, f: f, interval: interval}
+	if err := t.start(d); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *server) AfterFunc(d time.Duration, f func()) (*Timer, error) {
+	return newTimer(s.loopGroup.timerLoop(), d, 0, f)
+}
+
+func (s *server) Every(d time.Duration, f func()) (*Timer, error) {
+	if d <= 0 {
+		return nil, errors.ErrInvalidInterval
+	}
+	return newTimer(s.loopGroup.timerLoop(), d, d, f)
+}
+
+func (cli *client) AfterFunc(d time.Duration, f func()) (*Timer, error) {
+	return newTimer(cli.loopGroup.timerLoop(), d, 0, f)
+}
+
+func (cli *client) Every(d time.Duration, f func()) (*Timer, error) {
+	if d <= 0 {
+		return nil, errors.ErrInvalidInterval
+	}
+	return newTimer(cli.loopGroup.timerLoop(), d, d, f)
+}
+
+func (c *connection) AfterFunc(d time.Duration, f func()) (*Timer, error) {
+	// closed 只能在 loop 中访问，连接是否已经关闭由 start 投递的任务以及 fire 通过 cond 判断
+	t := &Timer{loop: c.loop, f: f, cond: c.IsOpen}
+	if err := t.start(d); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
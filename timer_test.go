@@ -0,0 +1,97 @@
+package jinx
+
+import (
+	"github.com/imlgw/jinx/internal/testutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServerTimers(t *testing.T) {
+	srv, err := NewServer("tcp", "127.0.0.1:9894", WithLoopNum(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.StartServer(t, srv, 3*time.Second)
+
+	fired := make(chan time.Time, 1)
+	start := time.Now()
+	if _, err := srv.AfterFunc(50*time.Millisecond, func() { fired <- time.Now() }); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case at := <-fired:
+		if at.Sub(start) < 50*time.Millisecond {
+			t.Fatalf("timer fired too early, %v", at.Sub(start))
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timer not fired")
+	}
+
+	// 取消的定时器不再执行
+	var canceled int32
+	timer, err := srv.AfterFunc(100*time.Millisecond, func() { atomic.StoreInt32(&canceled, 1) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !timer.Stop() || timer.Stop() {
+		t.Fatal("stop should only succeed once")
+	}
+
+	var ticks int32
+	every, err := srv.Every(20*time.Millisecond, func() { atomic.AddInt32(&ticks, 1) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	every.Stop()
+	n := atomic.LoadInt32(&ticks)
+	if n < 3 {
+		t.Fatalf("expected periodic timer to run several times, got %d", n)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&ticks) != n {
+		t.Fatal("periodic timer still running after stop")
+	}
+	if atomic.LoadInt32(&canceled) != 0 {
+		t.Fatal("canceled timer fired")
+	}
+
+	if _, err := srv.Every(0, func() {}); err == nil {
+		t.Fatal("expected error for non-positive interval")
+	}
+}
+
+func TestConnAfterFunc(t *testing.T) {
+	addr := "127.0.0.1:9895"
+	srv, err := NewServer("tcp", addr, WithLoopNum(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fired int32
+	srv.OnOpen(func(c Conn) {
+		// 延迟回复，连接关闭后不再执行
+		_, _ = c.AfterFunc(50*time.Millisecond, func() { _, _ = c.Write([]byte("hello")) })
+		_, _ = c.AfterFunc(300*time.Millisecond, func() { atomic.StoreInt32(&fired, 1) })
+	})
+	testutil.StartServer(t, srv, 3*time.Second)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("unexpected response %q, %v", buf[:n], err)
+	}
+	_ = conn.Close()
+
+	time.Sleep(400 * time.Millisecond)
+	if atomic.LoadInt32(&fired) != 0 {
+		t.Fatal("timer fired after conn closed")
+	}
+}
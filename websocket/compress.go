@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strings"
+	"sync"
+)
+
+// permessage-deflate，RFC 7692。总是协商 server_no_context_takeover 以及 client_no_context_takeover，
+// 每个消息单独压缩，连接上不需要保存压缩字典
+
+const deflateResponse = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+
+// deflateTail 补上 RFC 7692 7.2.2 中去掉的 0x00 0x00 0xff 0xff，再追加一个空的 final block，解压到末尾时返回 io.EOF
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+var flateReaderPool sync.Pool
+
+// acceptDeflate 是否接受客户端在 Sec-WebSocket-Extensions 中的 permessage-deflate 提议。
+// 不支持限制服务端的窗口大小，包含 server_max_window_bits 的提议不接受
+func acceptDeflate(values []string) bool {
+	for _, v := range values {
+		for _, offer := range strings.Split(v, ",") {
+			params := strings.Split(offer, ";")
+			if !strings.EqualFold(strings.TrimSpace(params[0]), "permessage-deflate") {
+				continue
+			}
+			ok := true
+			seen := make(map[string]bool)
+			for _, param := range params[1:] {
+				name := strings.ToLower(strings.TrimSpace(param))
+				if i := strings.IndexByte(name, '='); i >= 0 {
+					name = strings.TrimSpace(name[:i])
+				}
+				switch {
+				case seen[name]:
+					ok = false
+				case name == "server_no_context_takeover", name == "client_no_context_takeover",
+					name == "client_max_window_bits":
+				default:
+					ok = false
+				}
+				seen[name] = true
+			}
+			if ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compress 压缩一个消息并去掉末尾的 0x00 0x00 0xff 0xff
+func (s *Server) compress(p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, ok := s.flateWriters.Get().(*flate.Writer)
+	if ok {
+		fw.Reset(&buf)
+	} else {
+		var err error
+		if fw, err = flate.NewWriter(&buf, s.compressionLevel); err != nil {
+			return nil, err
+		}
+	}
+	defer s.flateWriters.Put(fw)
+	if _, err := fw.Write(p); err != nil {
+		return nil, err
+	}
+	if err := fw.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail[:4]), nil
+}
+
+// decompress 解压一个消息，解压后超过 max（> 0 时）返回 CloseMessageTooBig
+func decompress(p []byte, max int64) ([]byte, error) {
+	r := io.MultiReader(bytes.NewReader(p), bytes.NewReader(deflateTail))
+	fr, ok := flateReaderPool.Get().(io.ReadCloser)
+	if ok {
+		_ = fr.(flate.Resetter).Reset(r, nil)
+	} else {
+		fr = flate.NewReader(r)
+	}
+	defer flateReaderPool.Put(fr)
+
+	var src io.Reader = fr
+	if max > 0 {
+		src = io.LimitReader(fr, max+1)
+	}
+	out, err := io.ReadAll(src)
+	if err != nil {
+		return nil, &closeError{code: CloseInvalidPayload, reason: "invalid compressed data"}
+	}
+	if max > 0 && int64(len(out)) > max {
+		return nil, &closeError{code: CloseMessageTooBig, reason: "message too big"}
+	}
+	return out, nil
+}
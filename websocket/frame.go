@@ -0,0 +1,161 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"github.com/imlgw/jinx/errors"
+)
+
+// OpCode 帧类型，RFC 6455 5.2
+type OpCode byte
+
+const (
+	OpContinuation OpCode = 0x0
+	OpText         OpCode = 0x1
+	OpBinary       OpCode = 0x2
+	OpClose        OpCode = 0x8
+	OpPing         OpCode = 0x9
+	OpPong         OpCode = 0xa
+)
+
+// isControl close、ping、pong 为控制帧
+func (op OpCode) isControl() bool { return op&0x8 != 0 }
+
+// 关闭状态码，RFC 6455 7.4.1
+const (
+	CloseNormalClosure      = 1000
+	CloseGoingAway          = 1001
+	CloseProtocolError      = 1002
+	CloseUnsupportedData    = 1003
+	CloseNoStatusReceived   = 1005
+	CloseAbnormalClosure    = 1006
+	CloseInvalidPayload     = 1007
+	ClosePolicyViolation    = 1008
+	CloseMessageTooBig      = 1009
+	CloseMandatoryExtension = 1010
+	CloseInternalServerErr  = 1011
+)
+
+const (
+	finBit  = 0x80
+	rsv1Bit = 0x40 // permessage-deflate 压缩的消息
+	rsv2Bit = 0x20
+	rsv3Bit = 0x10
+	maskBit = 0x80
+
+	// maxControlPayload 控制帧的负载不能超过 125 字节
+	maxControlPayload = 125
+)
+
+// closeError 协议错误，回复 close 帧之后关闭连接
+type closeError struct {
+	code   int
+	reason string
+}
+
+func (e *closeError) Error() string { return e.reason }
+
+func protocolError(reason string) error {
+	return &closeError{code: CloseProtocolError, reason: reason}
+}
+
+type frame struct {
+	fin     bool
+	rsv1    bool
+	op      OpCode
+	payload []byte
+}
+
+// parseFrame 从 b 中解析一个客户端发送的帧，返回帧占用的字节数，数据不足时返回 errors.ErrIncomplete。
+// 负载超过 maxPayload（> 0 时）返回 CloseMessageTooBig，客户端的帧必须有掩码
+func parseFrame(b []byte, maxPayload int64) (frame, int, error) {
+	var f frame
+	if len(b) < 2 {
+		return f, 0, errors.ErrIncomplete
+	}
+	b0, b1 := b[0], b[1]
+	if b0&(rsv2Bit|rsv3Bit) != 0 {
+		return f, 0, protocolError("reserved bits set")
+	}
+	f.fin = b0&finBit != 0
+	f.rsv1 = b0&rsv1Bit != 0
+	f.op = OpCode(b0 & 0x0f)
+	if b1&maskBit == 0 {
+		return f, 0, protocolError("unmasked client frame")
+	}
+
+	hdr := 2
+	length := uint64(b1 & 0x7f)
+	switch length {
+	case 126:
+		if len(b) < 4 {
+			return f, 0, errors.ErrIncomplete
+		}
+		length = uint64(binary.BigEndian.Uint16(b[2:]))
+		hdr = 4
+	case 127:
+		if len(b) < 10 {
+			return f, 0, errors.ErrIncomplete
+		}
+		length = binary.BigEndian.Uint64(b[2:])
+		if length>>63 != 0 {
+			return f, 0, protocolError("invalid payload length")
+		}
+		hdr = 10
+	}
+	if f.op.isControl() && (!f.fin || length > maxControlPayload) {
+		return f, 0, protocolError("invalid control frame")
+	}
+	if maxPayload > 0 && length > uint64(maxPayload) {
+		return f, 0, &closeError{code: CloseMessageTooBig, reason: "message too big"}
+	}
+
+	total := hdr + 4 + int(length)
+	if len(b) < total {
+		return f, 0, errors.ErrIncomplete
+	}
+	mask := b[hdr : hdr+4]
+	f.payload = make([]byte, length)
+	copy(f.payload, b[hdr+4:total])
+	maskBytes(mask, f.payload)
+	return f, total, nil
+}
+
+func maskBytes(mask []byte, b []byte) {
+	for i := range b {
+		b[i] ^= mask[i&3]
+	}
+}
+
+// appendFrame 追加一个服务端发送的帧（没有掩码）
+func appendFrame(dst []byte, fin, rsv1 bool, op OpCode, payload []byte) []byte {
+	b0 := byte(op)
+	if fin {
+		b0 |= finBit
+	}
+	if rsv1 {
+		b0 |= rsv1Bit
+	}
+	n := len(payload)
+	switch {
+	case n <= 125:
+		dst = append(dst, b0, byte(n))
+	case n <= 0xffff:
+		dst = append(dst, b0, 126, byte(n>>8), byte(n))
+	default:
+		var l [8]byte
+		binary.BigEndian.PutUint64(l[:], uint64(n))
+		dst = append(append(dst, b0, 127), l[:]...)
+	}
+	return append(dst, payload...)
+}
+
+// validCloseCode 可以出现在 close 帧中的状态码，RFC 6455 7.4
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package websocket
+
+import (
+	"bytes"
+	"github.com/imlgw/jinx/errors"
+	"testing"
+)
+
+// clientFrame 构造客户端发送的带掩码的帧
+func clientFrame(fin, rsv1 bool, op OpCode, payload []byte) []byte {
+	raw := appendFrame(nil, fin, rsv1, op, payload)
+	hdr := raw[:len(raw)-len(payload)]
+	hdr[1] |= maskBit
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	masked := append([]byte(nil), payload...)
+	maskBytes(mask, masked)
+	return append(append(append([]byte(nil), hdr...), mask...), masked...)
+}
+
+func TestParseFrame(t *testing.T) {
+	for _, size := range []int{0, 125, 126, 0xffff, 0x10000} {
+		payload := bytes.Repeat([]byte{'x'}, size)
+		raw := clientFrame(true, false, OpBinary, payload)
+		for i := 0; i < len(raw) && i < 20; i++ {
+			if _, _, err := parseFrame(raw[:i], 0); err != errors.ErrIncomplete {
+				t.Fatalf("size %d: expected ErrIncomplete at %d, got %v", size, i, err)
+			}
+		}
+		f, n, err := parseFrame(append(raw, 0x81), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(raw) || !f.fin || f.op != OpBinary || !bytes.Equal(f.payload, payload) {
+			t.Fatalf("size %d: unexpected frame %d %v %v", size, n, f.fin, f.op)
+		}
+	}
+}
+
+func TestParseFrame_Errors(t *testing.T) {
+	unmasked := appendFrame(nil, true, false, OpText, []byte("hi"))
+	longPing := clientFrame(true, false, OpPing, make([]byte, 126))
+	fragmentedPing := clientFrame(false, false, OpPing, nil)
+	rsv2 := clientFrame(true, false, OpText, nil)
+	rsv2[0] |= rsv2Bit
+	tooBig := clientFrame(true, false, OpBinary, make([]byte, 11))
+
+	for _, c := range []struct {
+		raw  []byte
+		code int
+	}{
+		{unmasked, CloseProtocolError},
+		{longPing, CloseProtocolError},
+		{fragmentedPing, CloseProtocolError},
+		{rsv2, CloseProtocolError},
+		{tooBig, CloseMessageTooBig},
+	} {
+		_, _, err := parseFrame(c.raw, 10)
+		ce, ok := err.(*closeError)
+		if !ok || ce.code != c.code {
+			t.Errorf("%x: expected close code %d, got %v", c.raw, c.code, err)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	s := New(WithCompression(-1))
+	for _, msg := range [][]byte{nil, []byte("hello"), bytes.Repeat([]byte("imlgw.top "), 1000)} {
+		compressed, err := s.compress(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := decompress(compressed, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, msg) {
+			t.Fatalf("unexpected decompressed message %q", out)
+		}
+	}
+
+	compressed, _ := s.compress(bytes.Repeat([]byte{'a'}, 1000))
+	if _, err := decompress(compressed, 999); err == nil || err.(*closeError).code != CloseMessageTooBig {
+		t.Fatalf("expected CloseMessageTooBig, got %v", err)
+	}
+}
+
+func TestAcceptDeflate(t *testing.T) {
+	for _, c := range []struct {
+		offer string
+		ok    bool
+	}{
+		{"permessage-deflate", true},
+		{"permessage-deflate; client_max_window_bits", true},
+		{"permessage-deflate; server_max_window_bits=10, permessage-deflate", true},
+		{"permessage-deflate; server_max_window_bits=10", false},
+		{"permessage-deflate; client_no_context_takeover; client_no_context_takeover", false},
+		{"x-webkit-deflate-frame", false},
+	} {
+		if ok := acceptDeflate([]string{c.offer}); ok != c.ok {
+			t.Errorf("%q: expected %v", c.offer, c.ok)
+		}
+	}
+}
@@ -0,0 +1,467 @@
+package websocket
+
+import (
+	"compress/flate"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/imlgw/jinx"
+	"github.com/imlgw/jinx/errors"
+	jhttp "github.com/imlgw/jinx/http"
+	nethttp "net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Server 处理 WebSocket 升级握手，握手完成之后替换连接 pipeline 中的 http.Codec，
+// 在 eventloop 中解码、编码 RFC 6455 帧：掩码、分片、ping/pong、关闭握手以及 permessage-deflate
+type Server struct {
+	subprotocols     []string
+	checkOrigin      func(r *jhttp.Request) bool
+	enableCompress   bool
+	compressionLevel int
+	maxMessageSize   int64
+	closeTimeout     time.Duration
+
+	flateWriters sync.Pool
+
+	onOpen    func(c jinx.Conn, r *jhttp.Request)
+	onMessage func(c jinx.Conn, op OpCode, payload []byte)
+	onClose   func(c jinx.Conn, code int, reason string)
+}
+
+// Option Server 的可选配置
+type Option func(s *Server)
+
+// WithSubprotocols 支持的子协议，按照客户端 Sec-WebSocket-Protocol 中的顺序选择第一个支持的
+func WithSubprotocols(protocols ...string) Option {
+	return func(s *Server) {
+		s.subprotocols = protocols
+	}
+}
+
+// WithCheckOrigin 校验 Origin，默认只允许没有 Origin 或者 Origin 与 Host 相同的请求
+func WithCheckOrigin(f func(r *jhttp.Request) bool) Option {
+	return func(s *Server) {
+		s.checkOrigin = f
+	}
+}
+
+// WithCompression 客户端提议时协商 permessage-deflate，level 为 compress/flate 的压缩级别
+func WithCompression(level int) Option {
+	return func(s *Server) {
+		s.enableCompress = true
+		s.compressionLevel = level
+	}
+}
+
+// WithMaxMessageSize 消息（分片合并、解压之后）的最大长度，超过时以 1009 关闭连接，<= 0 表示不限制，默认 16MB
+func WithMaxMessageSize(n int64) Option {
+	return func(s *Server) {
+		s.maxMessageSize = n
+	}
+}
+
+// WithCloseTimeout 服务端发送 close 帧之后等待客户端回复的最长时间，超时后直接关闭连接，默认 5s
+func WithCloseTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.closeTimeout = d
+	}
+}
+
+func New(opts ...Option) *Server {
+	s := &Server{
+		compressionLevel: flate.BestSpeed,
+		maxMessageSize:   16 << 20,
+		closeTimeout:     5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// NewServer 创建只处理 WebSocket 的服务器，所有请求都尝试升级，需要同时处理普通 HTTP 请求时在 http.Handler 中调用 Upgrade
+func NewServer(network, addr string, ws *Server, opts ...jinx.Option) (jinx.Server, error) {
+	return jhttp.NewServer(network, addr, ws, opts...)
+}
+
+// OnOpen 握手完成，在 101 响应写入之后回调
+func (s *Server) OnOpen(f func(c jinx.Conn, r *jhttp.Request)) { s.onOpen = f }
+
+// OnMessage 收到一个完整的 text 或者 binary 消息（分片已经合并，已经解压），payload 在回调结束后仍然有效
+func (s *Server) OnMessage(f func(c jinx.Conn, op OpCode, payload []byte)) { s.onMessage = f }
+
+// OnClose 收到客户端的 close 帧或者客户端违反协议时回调，之后连接在 flush 完成后关闭。
+// 客户端没有发送 close 帧直接断开时只会回调 jinx 的 OnClose
+func (s *Server) OnClose(f func(c jinx.Conn, code int, reason string)) { s.onClose = f }
+
+// connState Server 在每个连接上的状态
+type connState struct {
+	buf      []byte // 尚未组成完整帧的数据
+	compress bool   // 协商了 permessage-deflate
+
+	// 正在接收的分片消息，fragOp 为 0 表示没有
+	fragOp         OpCode
+	fragCompressed bool
+	frag           []byte
+
+	closeSent bool // 已经发送 close 帧，不能再发送消息
+	closed    bool // 收到 close 帧或者协议错误，不再处理之后的数据
+}
+
+var keyGUID = []byte("258EAFA5-E914-47DA-95CA-C5AB0DC85B11")
+
+func computeAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key))
+	h.Write(keyGUID)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// ServeHTTP 实现 http.Handler，升级所有请求
+func (s *Server) ServeHTTP(w jhttp.ResponseWriter, r *jhttp.Request) {
+	_ = s.Upgrade(w, r)
+}
+
+// Upgrade 校验升级请求，成功时写入 101 响应并将连接 pipeline 中的 http.Codec 替换为 WebSocket 阶段，
+// 之后收到的数据按照帧解码。失败时通过 w 回复错误并返回 ErrBadHandshake，只能在 http.Handler 中调用
+func (s *Server) Upgrade(w jhttp.ResponseWriter, r *jhttp.Request) error {
+	fail := func(code int, msg string) error {
+		nethttp.Error(w, msg, code)
+		return errors.ErrBadHandshake
+	}
+	h := r.Header
+	if r.Method != nethttp.MethodGet || r.ProtoMinor < 1 {
+		return fail(nethttp.StatusMethodNotAllowed, "websocket: upgrade requires GET and HTTP/1.1")
+	}
+	if !hasToken(h["Connection"], "upgrade") || !hasToken(h["Upgrade"], "websocket") {
+		return fail(nethttp.StatusBadRequest, "websocket: missing upgrade headers")
+	}
+	if h.Get("Sec-Websocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return fail(nethttp.StatusUpgradeRequired, "websocket: unsupported version")
+	}
+	key := h.Get("Sec-Websocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return fail(nethttp.StatusBadRequest, "websocket: invalid Sec-WebSocket-Key")
+	}
+	checkOrigin := s.checkOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(r) {
+		return fail(nethttp.StatusForbidden, "websocket: origin not allowed")
+	}
+
+	p := r.Conn.Pipeline()
+	var codec jinx.Handler
+	for _, stage := range p.Handlers() {
+		if _, ok := stage.(*jhttp.Codec); ok {
+			codec = stage
+		}
+	}
+	if codec == nil {
+		return fail(nethttp.StatusInternalServerError, "websocket: connection has no http codec")
+	}
+
+	st := &connState{compress: s.enableCompress && acceptDeflate(h["Sec-Websocket-Extensions"])}
+	resp := []byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " +
+		computeAcceptKey(key) + "\r\n")
+	if protocol := s.selectSubprotocol(h["Sec-Websocket-Protocol"]); protocol != "" {
+		resp = append(resp, "Sec-WebSocket-Protocol: "+protocol+"\r\n"...)
+	}
+	if st.compress {
+		resp = append(resp, "Sec-WebSocket-Extensions: "+deflateResponse+"\r\n"...)
+	}
+	resp = append(resp, "\r\n"...)
+
+	ctx := p.Replace(codec, s)
+	ctx.State = st
+	if err := ctx.FireWrite(resp); err != nil {
+		return err
+	}
+	if s.onOpen != nil {
+		s.onOpen(r.Conn, r)
+	}
+	return nil
+}
+
+func (s *Server) selectSubprotocol(values []string) string {
+	for _, v := range values {
+		for _, protocol := range strings.Split(v, ",") {
+			protocol = strings.TrimSpace(protocol)
+			for _, supported := range s.subprotocols {
+				if protocol == supported {
+					return protocol
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// sameOrigin 没有 Origin 或者 Origin 的 host 与请求的 Host 相同
+func sameOrigin(r *jhttp.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// hasToken 逗号分隔的头部值中是否包含 token，不区分大小写
+func hasToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// state 返回连接上的状态，Upgrade 之前通过 jinx.WithPipeline 直接添加的阶段没有协商压缩
+func (s *Server) state(ctx *jinx.HandlerContext) *connState {
+	st, ok := ctx.State.(*connState)
+	if !ok {
+		st = new(connState)
+		ctx.State = st
+	}
+	return st
+}
+
+func (s *Server) HandleRead(ctx *jinx.HandlerContext, msg []byte) error {
+	st := s.state(ctx)
+	if st.closed {
+		return nil
+	}
+	st.buf = append(st.buf, msg...)
+	off := 0
+	for !st.closed && off < len(st.buf) {
+		f, n, err := parseFrame(st.buf[off:], s.maxMessageSize)
+		if err == errors.ErrIncomplete {
+			break
+		}
+		if err == nil {
+			off += n
+			err = s.handleFrame(ctx, st, f)
+		}
+		if err != nil {
+			return s.fail(ctx, st, err)
+		}
+	}
+	n := copy(st.buf, st.buf[off:])
+	st.buf = st.buf[:n]
+	return nil
+}
+
+// HandleWrite Conn.Send 的数据作为一个 binary 消息发送
+func (s *Server) HandleWrite(ctx *jinx.HandlerContext, msg []byte) error {
+	st := s.state(ctx)
+	if st.closeSent {
+		return errors.ErrCloseSent
+	}
+	return s.writeMessage(ctx, st, OpBinary, msg)
+}
+
+func (s *Server) handleFrame(ctx *jinx.HandlerContext, st *connState, f frame) error {
+	if f.rsv1 && (!st.compress || f.op.isControl() || f.op == OpContinuation) {
+		return protocolError("unexpected rsv1 bit")
+	}
+	switch f.op {
+	case OpPing:
+		if st.closeSent {
+			return nil
+		}
+		return s.writeFrame(ctx, true, false, OpPong, f.payload)
+	case OpPong:
+		return nil
+	case OpClose:
+		return s.handleClose(ctx, st, f.payload)
+	case OpText, OpBinary:
+		if st.fragOp != 0 {
+			return protocolError("expected continuation frame")
+		}
+		if f.fin {
+			return s.deliver(ctx, f.op, f.payload, f.rsv1)
+		}
+		st.fragOp, st.fragCompressed, st.frag = f.op, f.rsv1, f.payload
+		return nil
+	case OpContinuation:
+		if st.fragOp == 0 {
+			return protocolError("unexpected continuation frame")
+		}
+		if s.maxMessageSize > 0 && int64(len(st.frag)+len(f.payload)) > s.maxMessageSize {
+			return &closeError{code: CloseMessageTooBig, reason: "message too big"}
+		}
+		st.frag = append(st.frag, f.payload...)
+		if !f.fin {
+			return nil
+		}
+		op, payload, compressed := st.fragOp, st.frag, st.fragCompressed
+		st.fragOp, st.fragCompressed, st.frag = 0, false, nil
+		return s.deliver(ctx, op, payload, compressed)
+	default:
+		return protocolError("unknown opcode")
+	}
+}
+
+// deliver 解压、校验之后回调 OnMessage
+func (s *Server) deliver(ctx *jinx.HandlerContext, op OpCode, payload []byte, compressed bool) error {
+	if compressed {
+		var err error
+		if payload, err = decompress(payload, s.maxMessageSize); err != nil {
+			return err
+		}
+	}
+	if op == OpText && !utf8.Valid(payload) {
+		return &closeError{code: CloseInvalidPayload, reason: "invalid utf-8 text"}
+	}
+	if s.onMessage != nil {
+		s.onMessage(ctx.Conn(), op, payload)
+	}
+	return nil
+}
+
+// handleClose 收到 close 帧，没有发送过 close 帧时回复相同的状态码，flush 之后由服务端关闭 TCP 连接
+func (s *Server) handleClose(ctx *jinx.HandlerContext, st *connState, payload []byte) error {
+	code, reason := CloseNoStatusReceived, ""
+	switch {
+	case len(payload) == 1:
+		return protocolError("invalid close payload")
+	case len(payload) >= 2:
+		code = int(binary.BigEndian.Uint16(payload))
+		reason = string(payload[2:])
+		if !validCloseCode(code) {
+			return protocolError("invalid close code")
+		}
+		if !utf8.ValidString(reason) {
+			return &closeError{code: CloseInvalidPayload, reason: "invalid utf-8 close reason"}
+		}
+	}
+	st.closed = true
+	if !st.closeSent {
+		st.closeSent = true
+		// 回复客户端的状态码，不带原因
+		var echo []byte
+		if code != CloseNoStatusReceived {
+			echo = payload[:2]
+		}
+		if err := s.writeFrame(ctx, true, false, OpClose, echo); err != nil {
+			return err
+		}
+	}
+	if s.onClose != nil {
+		s.onClose(ctx.Conn(), code, reason)
+	}
+	return ctx.Conn().CloseAfterFlush()
+}
+
+// fail 协议错误时发送对应状态码的 close 帧之后关闭连接，其他错误交给 pipeline 关闭连接
+func (s *Server) fail(ctx *jinx.HandlerContext, st *connState, err error) error {
+	ce, ok := err.(*closeError)
+	if !ok {
+		return err
+	}
+	st.closed = true
+	if !st.closeSent {
+		st.closeSent = true
+		if err := s.writeFrame(ctx, true, false, OpClose, closePayload(ce.code, ce.reason)); err != nil {
+			return err
+		}
+	}
+	if s.onClose != nil {
+		s.onClose(ctx.Conn(), ce.code, ce.reason)
+	}
+	return ctx.Conn().CloseAfterFlush()
+}
+
+func closePayload(code int, reason string) []byte {
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	b := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(b, uint16(code))
+	return append(b, reason...)
+}
+
+// context 返回连接上 WebSocket 阶段的上下文
+func (s *Server) context(c jinx.Conn) (*jinx.HandlerContext, *connState, error) {
+	ctx := c.Pipeline().Context(s)
+	if ctx == nil {
+		return nil, nil, errors.ErrNotWebSocket
+	}
+	return ctx, s.state(ctx), nil
+}
+
+// WriteMessage 发送一个 text 或者 binary 消息，协商了 permessage-deflate 时压缩。
+// 与 jinx.Conn.Write 一样只能在连接所属的 eventloop 中调用，其他 goroutine 需要通过 Conn.AfterFunc 等投递到 eventloop
+func (s *Server) WriteMessage(c jinx.Conn, op OpCode, payload []byte) error {
+	if op != OpText && op != OpBinary {
+		return errors.ErrUnsupportedOp
+	}
+	ctx, st, err := s.context(c)
+	if err != nil {
+		return err
+	}
+	if st.closeSent {
+		return errors.ErrCloseSent
+	}
+	return s.writeMessage(ctx, st, op, payload)
+}
+
+// Ping 发送 ping 帧，data 不能超过 125 字节
+func (s *Server) Ping(c jinx.Conn, data []byte) error {
+	if len(data) > maxControlPayload {
+		return errors.ErrUnsupportedOp
+	}
+	ctx, st, err := s.context(c)
+	if err != nil {
+		return err
+	}
+	if st.closeSent {
+		return errors.ErrCloseSent
+	}
+	return s.writeFrame(ctx, true, false, OpPing, data)
+}
+
+// Close 发起关闭握手：发送 close 帧，收到客户端回复的 close 帧或者超时之后关闭连接
+func (s *Server) Close(c jinx.Conn, code int, reason string) error {
+	ctx, st, err := s.context(c)
+	if err != nil {
+		return err
+	}
+	if st.closeSent {
+		return nil
+	}
+	st.closeSent = true
+	if err := s.writeFrame(ctx, true, false, OpClose, closePayload(code, reason)); err != nil {
+		return err
+	}
+	_, err = c.AfterFunc(s.closeTimeout, func() { _ = c.Close() })
+	return err
+}
+
+func (s *Server) writeMessage(ctx *jinx.HandlerContext, st *connState, op OpCode, payload []byte) error {
+	if !st.compress {
+		return s.writeFrame(ctx, true, false, op, payload)
+	}
+	compressed, err := s.compress(payload)
+	if err != nil {
+		return err
+	}
+	return s.writeFrame(ctx, true, true, op, compressed)
+}
+
+func (s *Server) writeFrame(ctx *jinx.HandlerContext, fin, rsv1 bool, op OpCode, payload []byte) error {
+	return ctx.FireWrite(appendFrame(make([]byte, 0, len(payload)+10), fin, rsv1, op, payload))
+}
@@ -0,0 +1,238 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/imlgw/jinx"
+	jhttp "github.com/imlgw/jinx/http"
+	"github.com/imlgw/jinx/internal/testutil"
+	"io"
+	"net"
+	nethttp "net/http"
+	"testing"
+	"time"
+)
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dial 完成握手，extensions 不为空时提议 permessage-deflate
+func dial(t *testing.T, addr, extensions string) (*testClient, *nethttp.Response) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	req := "GET /ws HTTP/1.1\r\nHost: " + addr + "\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Protocol: chat, jinx\r\n"
+	if extensions != "" {
+		req += "Sec-WebSocket-Extensions: " + extensions + "\r\n"
+	}
+	if _, err := conn.Write([]byte(req + "\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := nethttp.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testClient{t: t, conn: conn, r: r}, resp
+}
+
+func (c *testClient) write(raw []byte) {
+	if _, err := c.conn.Write(raw); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// read 读取一个服务端发送的帧
+func (c *testClient) read() (byte, []byte) {
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(c.r, hdr); err != nil {
+		c.t.Fatal(err)
+	}
+	if hdr[1]&maskBit != 0 {
+		c.t.Fatal("server frame should not be masked")
+	}
+	n := int(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		b := make([]byte, 2)
+		_, _ = io.ReadFull(c.r, b)
+		n = int(binary.BigEndian.Uint16(b))
+	case 127:
+		b := make([]byte, 8)
+		_, _ = io.ReadFull(c.r, b)
+		n = int(binary.BigEndian.Uint64(b))
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		c.t.Fatal(err)
+	}
+	return hdr[0], payload
+}
+
+func (c *testClient) expectMessage(op OpCode, want []byte) {
+	c.t.Helper()
+	b0, payload := c.read()
+	if b0&rsv1Bit != 0 {
+		var err error
+		if payload, err = decompress(payload, 0); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+	if OpCode(b0&0x0f) != op || b0&finBit == 0 || !bytes.Equal(payload, want) {
+		c.t.Fatalf("unexpected frame %x %q, want %v %q", b0, payload, op, want)
+	}
+}
+
+func (c *testClient) expectClose(code int) {
+	c.t.Helper()
+	b0, payload := c.read()
+	if OpCode(b0&0x0f) != OpClose || len(payload) < 2 || int(binary.BigEndian.Uint16(payload)) != code {
+		c.t.Fatalf("expected close %d, got %x %q", code, b0, payload)
+	}
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		c.t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	addr := "127.0.0.1:9910"
+	ws := New(WithSubprotocols("jinx"), WithCompression(-1), WithMaxMessageSize(1<<20))
+	closed := make(chan int, 1)
+	ws.OnOpen(func(c jinx.Conn, r *jhttp.Request) {
+		_ = ws.WriteMessage(c, OpText, []byte("welcome"))
+	})
+	ws.OnMessage(func(c jinx.Conn, op OpCode, payload []byte) {
+		if string(payload) == "bye" {
+			_ = ws.Close(c, CloseGoingAway, "bye")
+			return
+		}
+		_ = ws.WriteMessage(c, op, payload)
+	})
+	ws.OnClose(func(c jinx.Conn, code int, reason string) { closed <- code })
+	srv, err := jhttp.NewServer("tcp", addr, jhttp.HandlerFunc(func(w jhttp.ResponseWriter, r *jhttp.Request) {
+		if r.URL.Path == "/ws" {
+			_ = ws.Upgrade(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("plain"))
+	}), jinx.WithLoopNum(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.StartServer(t, srv, 3*time.Second)
+
+	c, resp := dial(t, addr, "")
+	defer c.conn.Close()
+	if resp.StatusCode != nethttp.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" ||
+		resp.Header.Get("Sec-WebSocket-Protocol") != "jinx" ||
+		resp.Header.Get("Sec-WebSocket-Extensions") != "" {
+		t.Fatalf("unexpected handshake response %d %v", resp.StatusCode, resp.Header)
+	}
+	c.expectMessage(OpText, []byte("welcome"))
+
+	// 分片的消息中间穿插 ping，一次写入多个帧
+	var raw []byte
+	raw = append(raw, clientFrame(false, false, OpText, []byte("hello "))...)
+	raw = append(raw, clientFrame(true, false, OpPing, []byte("p"))...)
+	raw = append(raw, clientFrame(false, false, OpContinuation, []byte("wor"))...)
+	raw = append(raw, clientFrame(true, false, OpContinuation, []byte("ld"))...)
+	big := bytes.Repeat([]byte{'b'}, 70000)
+	raw = append(raw, clientFrame(true, false, OpBinary, big)...)
+	c.write(raw[:7])
+	time.Sleep(20 * time.Millisecond)
+	c.write(raw[7:])
+	c.expectMessage(OpPong, []byte("p"))
+	c.expectMessage(OpText, []byte("hello world"))
+	c.expectMessage(OpBinary, big)
+
+	// 客户端发起关闭握手
+	c.write(clientFrame(true, false, OpClose, closePayload(CloseNormalClosure, "done")))
+	c.expectClose(CloseNormalClosure)
+	if code := <-closed; code != CloseNormalClosure {
+		t.Fatalf("unexpected close code %d", code)
+	}
+
+	// permessage-deflate，服务端发起关闭握手
+	c, resp = dial(t, addr, "permessage-deflate; client_max_window_bits")
+	defer c.conn.Close()
+	if resp.Header.Get("Sec-WebSocket-Extensions") != deflateResponse {
+		t.Fatalf("unexpected extensions %q", resp.Header.Get("Sec-WebSocket-Extensions"))
+	}
+	c.expectMessage(OpText, []byte("welcome"))
+	msg := bytes.Repeat([]byte("compressed "), 100)
+	compressed, _ := ws.compress(msg)
+	c.write(clientFrame(true, true, OpText, compressed))
+	c.expectMessage(OpText, msg)
+	c.write(clientFrame(true, false, OpText, []byte("bye")))
+	b0, payload := c.read()
+	if OpCode(b0&0x0f) != OpClose || int(binary.BigEndian.Uint16(payload)) != CloseGoingAway {
+		t.Fatalf("expected close frame, got %x %q", b0, payload)
+	}
+	c.write(clientFrame(true, false, OpClose, payload[:2]))
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	if code := <-closed; code != CloseGoingAway {
+		t.Fatalf("unexpected close code %d", code)
+	}
+
+	// 协议错误：没有协商压缩却设置了 rsv1，非法的 utf-8
+	for _, bad := range []struct {
+		raw  []byte
+		code int
+	}{
+		{clientFrame(true, true, OpText, []byte("x")), CloseProtocolError},
+		{clientFrame(true, false, OpText, []byte{0xff, 0xfe}), CloseInvalidPayload},
+		{clientFrame(true, false, OpContinuation, []byte("x")), CloseProtocolError},
+	} {
+		c, _ := dial(t, addr, "")
+		c.expectMessage(OpText, []byte("welcome"))
+		c.write(bad.raw)
+		c.expectClose(bad.code)
+		<-closed
+		_ = c.conn.Close()
+	}
+}
+
+func TestWebSocketBadHandshake(t *testing.T) {
+	addr := "127.0.0.1:9911"
+	srv, err := NewServer("tcp", addr, New(), jinx.WithLoopNum(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.StartServer(t, srv, 3*time.Second)
+
+	for _, c := range []struct {
+		req  string
+		code int
+	}{
+		{"GET / HTTP/1.1\r\nHost: a\r\n\r\n", nethttp.StatusBadRequest},
+		{"GET / HTTP/1.1\r\nHost: a\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 8\r\n\r\n",
+			nethttp.StatusUpgradeRequired},
+		{"GET / HTTP/1.1\r\nHost: a\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\n" +
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nOrigin: http://evil.com\r\n\r\n", nethttp.StatusForbidden},
+	} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+		_, _ = conn.Write([]byte(c.req))
+		resp, err := nethttp.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != c.code {
+			t.Fatalf("unexpected status %d, want %d", resp.StatusCode, c.code)
+		}
+		_ = conn.Close()
+	}
+}